@@ -0,0 +1,143 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every api key, the part until the second underscore is stored in clear for lookup
+const apiKeyPrefix = "shp_"
+
+// apiKeyUsedInterval limit how often last_used_at is written for a busy key
+const apiKeyUsedInterval = time.Minute
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid api key")
+	ErrCourseNotAllowed  = errors.New("course not allowed for api key")
+	ErrPermissionMissing = errors.New("permission missing")
+)
+
+// CreateAPIKey is a function to create an api key with the service role allowed
+// to push scores on courseIds, the key is only returned here
+func CreateAPIKey(request APIKeyRequest) (apiKey APIKey, key string, err error) {
+	if request.Name == "" || len(request.CourseIDs) == 0 {
+		return apiKey, "", ErrCantBeEmpty
+	}
+
+	var courses []Course
+	if err := DB.Where("id IN ?", request.CourseIDs).Find(&courses).Error; err != nil {
+		return apiKey, "", err
+	}
+	if len(courses) != len(request.CourseIDs) {
+		return apiKey, "", ErrNotFound
+	}
+
+	roleId, err := GetRoleIdByName(RoleService)
+	if err != nil {
+		return apiKey, "", err
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		return apiKey, "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return apiKey, "", err
+	}
+
+	// underscore separates the prefix from the secret so it can not be part of the prefix
+	prefix = strings.ReplaceAll(prefix, "_", "-")
+	key = apiKeyPrefix + prefix + "_" + secret
+
+	apiKey = APIKey{
+		Name:    request.Name,
+		Prefix:  prefix,
+		KeyHash: hashToken(key),
+		RoleID:  roleId,
+		Courses: courses,
+	}
+	if err := DB.Create(&apiKey).Error; err != nil {
+		return APIKey{}, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// GetAPIKeys is a function to get all api keys with their allowed courses
+func GetAPIKeys() ([]APIKey, error) {
+	var apiKeys []APIKey
+	res := DB.Preload("Courses").Find(&apiKeys)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey is a function to revoke api key based on apiKeyId
+func RevokeAPIKey(apiKeyId int) error {
+	res := DB.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", apiKeyId).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey is a function to find the active api key matching key and
+// check its role has permission, last_used_at is updated on success
+func AuthenticateAPIKey(key string, permission string) (APIKey, error) {
+	var apiKey APIKey
+
+	rest := strings.TrimPrefix(key, apiKeyPrefix)
+	prefix, _, ok := strings.Cut(rest, "_")
+	if rest == key || !ok {
+		return apiKey, ErrInvalidAPIKey
+	}
+
+	res := DB.Preload("Role.Permissions").
+		Where("prefix = ? AND key_hash = ?", prefix, hashToken(key)).
+		First(&apiKey)
+	if res.Error != nil || apiKey.RevokedAt != nil {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	var granted bool
+	for _, p := range apiKey.Role.Permissions {
+		if p.Name == permission {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return APIKey{}, ErrPermissionMissing
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyUsedInterval {
+		DB.Model(&apiKey).Update("last_used_at", now)
+	}
+
+	return apiKey, nil
+}
+
+// apiKeyAllowsCourse check if apiKeyId can push scores on courseId
+func apiKeyAllowsCourse(tx *gorm.DB, apiKeyId int, courseId int) (bool, error) {
+	var count int64
+	res := tx.Table("api_key_courses").
+		Where("api_key_id = ? AND course_id = ?", apiKeyId, courseId).
+		Count(&count)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return count > 0, nil
+}
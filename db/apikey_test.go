@@ -0,0 +1,83 @@
+//go:build cgo
+
+package db
+
+import "testing"
+
+// TestAuthenticateAPIKey verifies only an active key with its prefix and the permission is accepted
+func TestAuthenticateAPIKey(t *testing.T) {
+	openTestDB(t)
+	_, course, _ := createTestLab(t, "XII TKJ 1", "Linux", "lab-1")
+
+	apiKey, key, err := CreateAPIKey(APIKeyRequest{Name: "grader", CourseIDs: []int{course.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherKey, err := CreateAPIKey(APIKeyRequest{Name: "revoked", CourseIDs: []int{course.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeAPIKey(other.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated, err := AuthenticateAPIKey(key, PermScoresPush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticated.ID != apiKey.ID {
+		t.Errorf("expected api key %d, got %d", apiKey.ID, authenticated.ID)
+	}
+
+	cases := []struct {
+		name       string
+		key        string
+		permission string
+		expected   error
+	}{
+		{"missing shp_ prefix", key[len(apiKeyPrefix):], PermScoresPush, ErrInvalidAPIKey},
+		{"missing secret", apiKeyPrefix + apiKey.Prefix, PermScoresPush, ErrInvalidAPIKey},
+		{"wrong secret", apiKeyPrefix + apiKey.Prefix + "_wrong", PermScoresPush, ErrInvalidAPIKey},
+		{"unknown key", apiKeyPrefix + "unknown_secret", PermScoresPush, ErrInvalidAPIKey},
+		{"revoked key", otherKey, PermScoresPush, ErrInvalidAPIKey},
+		{"permission missing", key, PermScoresOverride, ErrPermissionMissing},
+	}
+	for _, tc := range cases {
+		if _, err := AuthenticateAPIKey(tc.key, tc.permission); err != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
+
+// TestPushScoreByAPIKey verifies an api key only push scores of students on its courses
+func TestPushScoreByAPIKey(t *testing.T) {
+	openTestDB(t)
+	class, course, _ := createTestLab(t, "XII TKJ 1", "Linux", "lab-1")
+	_, _, _ = createTestLab(t, "XII TKJ 2", "Network", "lab-2")
+	createTestUser(t, "student", RoleStudent, class.ID)
+	createTestUser(t, "instructor", RoleInstructor, class.ID)
+
+	apiKey, _, err := CreateAPIKey(APIKeyRequest{Name: "grader", CourseIDs: []int{course.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PushScoreByAPIKey(apiKey.ID, ScorePush{Username: "student", Lab: "lab-1", Score: 90})
+	if err != ScoreUpdated {
+		t.Fatalf("allowed course: expected %v, got %v", ScoreUpdated, err)
+	}
+	err = PushScoreByAPIKey(apiKey.ID, ScorePush{Username: "student", Lab: "lab-2", Score: 90})
+	if err != ErrCourseNotAllowed {
+		t.Errorf("other course: expected %v, got %v", ErrCourseNotAllowed, err)
+	}
+	err = PushScoreByAPIKey(apiKey.ID, ScorePush{Username: "instructor", Lab: "lab-1", Score: 90})
+	if err != ErrUnauthorized {
+		t.Errorf("staff user: expected %v, got %v", ErrUnauthorized, err)
+	}
+
+	var scores []Score
+	DB.Find(&scores)
+	if len(scores) != 1 || scores[0].Score != 90 {
+		t.Errorf("expected only the allowed score saved, got %+v", scores)
+	}
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"time"
+)
+
+// GetArtifact is a function to get the artifact uploaded with attemptId
+func GetArtifact(attemptId int) (Artifact, error) {
+	var artifact Artifact
+	if err := DB.Where("attempt_id = ?", attemptId).First(&artifact).Error; err != nil {
+		return artifact, ErrNotFound
+	}
+
+	return artifact, nil
+}
+
+// GetAttemptUserId is a function to get the user of attemptId
+func GetAttemptUserId(attemptId int) (int, error) {
+	var attempt Attempt
+	if err := DB.Where("id = ?", attemptId).First(&attempt).Error; err != nil {
+		return 0, ErrNotFound
+	}
+
+	return attempt.UserID, nil
+}
+
+// GetExpiredArtifacts is a function to get at most limit artifacts expired before
+func GetExpiredArtifacts(before time.Time, limit int) ([]Artifact, error) {
+	var artifacts []Artifact
+	res := DB.Where("expires_at < ?", before).Order("expires_at").Limit(limit).Find(&artifacts)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return artifacts, nil
+}
+
+// DeleteArtifact is a function to delete artifact by id, its blob is deleted by the caller
+func DeleteArtifact(artifactId int) error {
+	return DB.Where("id = ?", artifactId).Delete(&Artifact{}).Error
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// recordAttempt save score as a new attempt of userId on labId and update the
+// score row with the scoring policy of the lab, ScoreNotUpdated is returned when it did not change
+func recordAttempt(userId int, labId int, score ScorePush, apiKeyId *int) error {
+	var changed bool
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changed, err = recordAttemptTx(tx, userId, labId, score, apiKeyId)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		publishScores(ScoreSourcePush, scoreRef{userId, labId})
+		return ScoreUpdated
+	}
+	return ScoreNotUpdated
+}
+
+// recordAttemptTx is recordAttempt inside tx, the attempt is dated when it is received
+func recordAttemptTx(tx *gorm.DB, userId int, labId int, score ScorePush, apiKeyId *int) (bool, error) {
+	// lock the score row so concurrent pushes compute the policy one after the other
+	var scores Score
+	res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND lab_id = ?", userId, labId).
+		Limit(1).Find(&scores)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	pushedAt := time.Now()
+
+	// refuse pushes outside the window of the lab for the class of the user
+	var user User
+	if err := tx.Where("id = ?", userId).First(&user).Error; err != nil {
+		return false, ErrNotFound
+	}
+	var lab Lab
+	if err := tx.Where("id = ?", labId).First(&lab).Error; err != nil {
+		return false, ErrNotFound
+	}
+	window, err := resolveWindow(tx, lab, user.ClassID)
+	if err != nil {
+		return false, err
+	}
+	if err := checkWindow(window, pushedAt); err != nil {
+		return false, err
+	}
+
+	attempt := Attempt{
+		UserID:        userId,
+		LabID:         labId,
+		Score:         score.Score,
+		IP:            score.IP,
+		ClientVersion: score.ClientVersion,
+		Details:       score.Details,
+		APIKeyID:      apiKeyId,
+		CreatedAt:     pushedAt,
+	}
+	if err := tx.Create(&attempt).Error; err != nil {
+		return false, err
+	}
+
+	if err := createChecks(tx, attempt.ID, score.Checks); err != nil {
+		return false, err
+	}
+
+	if score.Artifact != nil {
+		artifact := *score.Artifact
+		artifact.ID = 0
+		artifact.AttemptID = attempt.ID
+		if err := tx.Create(&artifact).Error; err != nil {
+			return false, err
+		}
+	}
+
+	// a new attempt replace a manual score unless it is locked
+	if res.RowsAffected > 0 {
+		if err := releaseOverride(tx, scores); err != nil {
+			return false, err
+		}
+	}
+
+	return recomputeScore(tx, userId, labId)
+}
+
+// dedupeScores keep the last updated score of each student on each lab, concurrent first pushes
+// could create several before scores were unique
+func dedupeScores() error {
+	if !DB.Migrator().HasTable(&Score{}) {
+		return nil
+	}
+
+	return DB.Exec(`DELETE FROM scores WHERE id IN (
+		SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id, lab_id ORDER BY updated_at DESC, id DESC) AS n FROM scores) ranked
+		WHERE n > 1)`).Error
+}
+
+// seedLegacyAttempts create an attempt for every score without attempts, pushed before attempts were recorded
+func seedLegacyAttempts() error {
+	return DB.Exec(`INSERT INTO attempts (user_id, lab_id, score, ip, client_version, details, created_at)
+		SELECT scores.user_id, scores.lab_id, scores.score, '', 'legacy', '', scores.updated_at FROM scores
+		WHERE NOT EXISTS (SELECT 1 FROM attempts WHERE attempts.user_id = scores.user_id AND attempts.lab_id = scores.lab_id)`).Error
+}
+
+// GetAttempts is a function to get attempts of userId from newest to oldest,
+// filtered by lab name when not empty
+func GetAttempts(userId int, labName string) ([]AttemptLog, error) {
+	var attempts []AttemptLog
+
+	query := DB.Table("attempts").
+		Select("attempts.id, labs.name AS lab, attempts.score, attempts.penalty, attempts.ip, attempts.client_version, attempts.details, attempts.created_at, "+
+			"EXISTS (SELECT 1 FROM artifacts WHERE artifacts.attempt_id = attempts.id) AS artifact").
+		Joins("JOIN labs ON labs.id = attempts.lab_id").
+		Where("attempts.user_id = ?", userId)
+	if labName != "" {
+		var lab Lab
+		if err := DB.Where("name = ?", labName).First(&lab).Error; err != nil {
+			return nil, ErrNotFound
+		}
+		query = query.Where("attempts.lab_id = ?", lab.ID)
+	}
+
+	if err := query.Order("attempts.created_at DESC, attempts.id DESC").Scan(&attempts).Error; err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// GetAttemptCounts is a function to count the attempts of userId on each lab of courseId, by lab id
+func GetAttemptCounts(userId int, courseId int) (map[int]int, error) {
+	var rows []struct {
+		LabID int
+		Count int
+	}
+	err := DB.Table("attempts").
+		Select("attempts.lab_id, COUNT(*) AS count").
+		Joins("JOIN labs ON labs.id = attempts.lab_id").
+		Where("attempts.user_id = ? AND labs.course_id = ?", userId, courseId).
+		Group("attempts.lab_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[int]int{}
+	for _, row := range rows {
+		counts[row.LabID] = row.Count
+	}
+
+	return counts, nil
+}
@@ -1,13 +1,13 @@
 package db
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"strconv"
+	"time"
 )
 
-const (
-	salt = "shopiea"
-)
+// minPasswordLength is enforced when users choose their own password
+const minPasswordLength = 8
 
 // IsAdmin Check User if admin or not
 func IsAdmin(userId string) bool {
@@ -20,29 +20,89 @@ func IsAdmin(userId string) bool {
 	return user.Role.Name == "admin"
 }
 
-// ValidationUserLogin for user login
-func ValidationUserLogin(login Login) (string, bool) {
+// ChangePassword is a function to change password of userId after checking the old one,
+// other sessions of the user are revoked
+func ChangePassword(userId int, change PasswordChange) error {
+	if change.OldPassword == "" || change.NewPassword == "" {
+		return ErrCantBeEmpty
+	}
+	if len(change.NewPassword) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+
 	var user User
-	if err := DB.Where("username = ?", login.Username).First(&user).Error; err != nil {
-		return "", false
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return ErrNotFound
 	}
-	userId := strconv.FormatUint(uint64(user.ID), 10)
 
-	return userId, verifyPassword(login.Password, user.Password)
-}
+	if user.AuthSource != SourceLocal {
+		return ErrExternalAccount
+	}
 
-// TODO: change static salt for password to dynamic salt
+	if !verifyPassword(change.OldPassword, user.Password, user.PasswordScheme) {
+		return ErrInvalidPassword
+	}
 
-// VerifyPassword takes a plain text password and a hashed and salted password, and returns whether they match
-func verifyPassword(plainPassword, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword+salt))
-	return err == nil
+	hashedPassword, scheme, err := hashPassword(change.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+			"password":                 hashedPassword,
+			"password_scheme":          scheme,
+			"must_change_password":     false,
+			"temp_password_expires_at": nil,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		return revokeUserSessions(tx, userId)
+	})
 }
 
-func hashAndSaltPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.DefaultCost)
+// ResetPassword is a function to replace password of userId with a random temporary
+// password, the user must change it on next login before it expires
+func ResetPassword(userId int) (tempPassword string, expiresAt time.Time, err error) {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return "", expiresAt, ErrNotFound
+	}
+
+	if user.AuthSource != SourceLocal {
+		return "", expiresAt, ErrExternalAccount
+	}
+
+	tempPassword, err = randomToken(12)
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	hashedPassword, scheme, err := hashPassword(tempPassword)
 	if err != nil {
-		return "", err
+		return "", expiresAt, err
 	}
-	return string(hash), nil
+
+	expiresAt = time.Now().Add(passwordConfig.TempPasswordTTL)
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+			"password":                 hashedPassword,
+			"password_scheme":          scheme,
+			"must_change_password":     true,
+			"temp_password_expires_at": expiresAt,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		return revokeUserSessions(tx, userId)
+	})
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	return tempPassword, expiresAt, nil
 }
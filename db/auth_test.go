@@ -0,0 +1,146 @@
+//go:build cgo
+
+package db
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+// createTestLocalUser create a student with password and a refresh token
+func createTestLocalUser(t *testing.T, username string, password string) User {
+	defer func(config PasswordConfig) { passwordConfig = config }(passwordConfig)
+	passwordConfig.Algorithm = SchemeBcrypt
+	passwordConfig.BcryptCost = bcrypt.MinCost
+
+	user := createTestUser(t, username, RoleStudent, 1)
+	hashedPassword, scheme, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Model(&user).Updates(map[string]interface{}{"password": hashedPassword, "password_scheme": scheme}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateRefreshToken(user.ID, false, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// assertSessionsRevoked check the refresh tokens of user are revoked and the
+// access tokens of its previous token version are refused
+func assertSessionsRevoked(t *testing.T, user User) {
+	t.Helper()
+
+	var active int64
+	DB.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active)
+	if active != 0 {
+		t.Errorf("expected every refresh token revoked, %d active", active)
+	}
+
+	revoked, err := IsTokenRevoked(user.ID, "access", user.TokenVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("expected an access token of the previous version to be revoked")
+	}
+	revoked, _ = IsTokenRevoked(user.ID, "access", user.TokenVersion+1)
+	if revoked {
+		t.Error("expected an access token of the new version to be accepted")
+	}
+}
+
+// getTestUser reload user from the database
+func getTestUser(t *testing.T, userId int) User {
+	var user User
+	if err := DB.First(&user, userId).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// TestChangePassword verifies the old password is checked, the sessions are revoked
+// and a pending password change is cleared
+func TestChangePassword(t *testing.T) {
+	openTestDB(t)
+	user := createTestLocalUser(t, "wayan", "old-password")
+	DB.Model(&user).Update("must_change_password", true)
+
+	err := ChangePassword(user.ID, PasswordChange{OldPassword: "wrong", NewPassword: "new-password"})
+	if err != ErrInvalidPassword {
+		t.Errorf("expected %v, got %v", ErrInvalidPassword, err)
+	}
+	err = ChangePassword(user.ID, PasswordChange{OldPassword: "old-password", NewPassword: "short"})
+	if err != ErrPasswordTooShort {
+		t.Errorf("expected %v, got %v", ErrPasswordTooShort, err)
+	}
+
+	err = ChangePassword(user.ID, PasswordChange{OldPassword: "old-password", NewPassword: "new-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := getTestUser(t, user.ID)
+	if !verifyPassword("new-password", changed.Password, changed.PasswordScheme) {
+		t.Error("expected the new password to be stored")
+	}
+	if changed.MustChangePassword {
+		t.Error("expected the pending password change to be cleared")
+	}
+	assertSessionsRevoked(t, user)
+}
+
+// TestResetPassword verifies the temporary password must be changed and the sessions are revoked
+func TestResetPassword(t *testing.T) {
+	openTestDB(t)
+	user := createTestLocalUser(t, "wayan", "old-password")
+
+	tempPassword, expiresAt, err := ResetPassword(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := getTestUser(t, user.ID)
+	if !verifyPassword(tempPassword, reset.Password, reset.PasswordScheme) {
+		t.Error("expected the temporary password to be stored")
+	}
+	if !reset.MustChangePassword {
+		t.Error("expected the temporary password to be changed on next login")
+	}
+	if reset.TempPasswordExpiresAt == nil || !reset.TempPasswordExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected the temporary password to expire at %v, got %v", expiresAt, reset.TempPasswordExpiresAt)
+	}
+	assertSessionsRevoked(t, user)
+}
+
+// TestPatchUserPassword verifies a password set by an admin revoke the sessions and
+// must be changed, other updates keep the sessions
+func TestPatchUserPassword(t *testing.T) {
+	openTestDB(t)
+	user := createTestLocalUser(t, "wayan", "old-password")
+
+	if err := PatchUsersByUsersId(user.ID, User{Name: "I Wayan"}); err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := IsTokenRevoked(user.ID, "access", user.TokenVersion)
+	if revoked {
+		t.Error("expected an update without password to keep the sessions")
+	}
+
+	if err := PatchUsersByUsersId(user.ID, User{Password: "admin-password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	patched := getTestUser(t, user.ID)
+	if !verifyPassword("admin-password", patched.Password, patched.PasswordScheme) {
+		t.Error("expected the new password to be stored")
+	}
+	if !patched.MustChangePassword {
+		t.Error("expected the password set by an admin to be changed on next login")
+	}
+	assertSessionsRevoked(t, user)
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SourceLocal is the auth source of users with a password in the users table
+	SourceLocal = "local"
+	// SourceOIDC is the auth source of users provisioned from an OpenID Connect login
+	SourceOIDC = "oidc"
+)
+
+var (
+	ErrUnknownBackend     = errors.New("unknown auth backend")
+	ErrNoGroupMapping     = errors.New("no group mapped to a class")
+	ErrAuthSourceMismatch = errors.New("user belongs to another auth source")
+	ErrExternalAccount    = errors.New("password is managed by an external auth source")
+)
+
+// Identity is a user authenticated by a backend, UserID is only known for local users
+type Identity struct {
+	UserID   int
+	Username string
+	Name     string
+	Groups   []string
+}
+
+// AuthBackend verify a username and password
+type AuthBackend interface {
+	Name() string
+	Authenticate(username, password string) (Identity, error)
+}
+
+// GroupMapping map a directory group to the class and role of provisioned users
+type GroupMapping struct {
+	Group string
+	Class string
+	Role  string
+}
+
+// AuthConfig is a struct to store authentication backends configuration
+type AuthConfig struct {
+	// Backends tried in order on password login, comma separated local and ldap
+	Backends string
+	// GroupMap is group:class:role separated by comma, role is student when omitted
+	GroupMap string
+	LDAP     LDAPConfig
+}
+
+// authBackends are tried in order by ValidationUserLogin
+var authBackends = []AuthBackend{localBackend{}}
+
+// groupMappings are checked in order, the first one matching a group of the user wins
+var groupMappings []GroupMapping
+
+// InitAuthBackends set the password login backends and the group mapping
+func (config AuthConfig) InitAuthBackends() error {
+	mappings, err := parseGroupMap(config.GroupMap)
+	if err != nil {
+		return err
+	}
+
+	if config.Backends == "" {
+		config.Backends = SourceLocal
+	}
+
+	var backends []AuthBackend
+	for _, name := range strings.Split(config.Backends, ",") {
+		switch strings.TrimSpace(name) {
+		case SourceLocal:
+			backends = append(backends, localBackend{})
+		case SourceLDAP:
+			backend, err := newLDAPBackend(config.LDAP)
+			if err != nil {
+				return err
+			}
+			backends = append(backends, backend)
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownBackend, name)
+		}
+	}
+
+	authBackends = backends
+	groupMappings = mappings
+	return nil
+}
+
+// parseGroupMap parse group:class:role entries separated by comma
+func parseGroupMap(groupMap string) ([]GroupMapping, error) {
+	var mappings []GroupMapping
+	if strings.TrimSpace(groupMap) == "" {
+		return mappings, nil
+	}
+
+	for _, entry := range strings.Split(groupMap, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid group mapping %q, expected group:class:role", entry)
+		}
+
+		mapping := GroupMapping{Group: parts[0], Class: parts[1], Role: RoleStudent}
+		if len(parts) == 3 && parts[2] != "" {
+			mapping.Role = parts[2]
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, nil
+}
+
+// matchGroup returns the first mapping matching one of groups
+func matchGroup(groups []string) (GroupMapping, bool) {
+	for _, mapping := range groupMappings {
+		for _, group := range groups {
+			if strings.EqualFold(mapping.Group, group) {
+				return mapping, true
+			}
+		}
+	}
+
+	return GroupMapping{}, false
+}
+
+// ValidationUserLogin for user login, every configured backend is tried in order
+// and users of an external backend are provisioned on their first login
+func ValidationUserLogin(login Login) (string, bool) {
+	if login.Username == "" || login.Password == "" {
+		return "", false
+	}
+
+	for _, backend := range authBackends {
+		identity, err := backend.Authenticate(login.Username, login.Password)
+		if err != nil {
+			continue
+		}
+
+		if identity.UserID == 0 {
+			user, err := ProvisionUser(backend.Name(), identity)
+			if err != nil {
+				return "", false
+			}
+			identity.UserID = user.ID
+		}
+
+		return strconv.Itoa(identity.UserID), true
+	}
+
+	return "", false
+}
+
+// ProvisionUser is a function to create or update the user of an external identity,
+// class and role come from the first mapped group. A new user without mapped group is refused
+func ProvisionUser(source string, identity Identity) (user User, err error) {
+	if identity.Username == "" {
+		return user, ErrCantBeEmpty
+	}
+
+	mapping, mapped := matchGroup(identity.Groups)
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var classId, roleId int
+		if mapped {
+			var class Class
+			if err := tx.Where(Class{Name: mapping.Class}).FirstOrCreate(&class).Error; err != nil {
+				return err
+			}
+			classId = class.ID
+
+			var role Role
+			if err := tx.Where("name = ?", mapping.Role).First(&role).Error; err != nil {
+				return fmt.Errorf("role %q of group %q: %w", mapping.Role, mapping.Group, ErrNotFound)
+			}
+			roleId = role.ID
+		}
+
+		if err := tx.Where("username = ?", identity.Username).First(&user).Error; err != nil {
+			if !mapped {
+				return ErrNoGroupMapping
+			}
+
+			// external users never log in with this password, it only fills the column
+			password, err := randomToken(32)
+			if err != nil {
+				return err
+			}
+			hashedPassword, scheme, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			name := identity.Name
+			if name == "" {
+				name = identity.Username
+			}
+
+			user = User{
+				Username:       identity.Username,
+				Password:       hashedPassword,
+				PasswordScheme: scheme,
+				Name:           name,
+				RoleID:         roleId,
+				ClassID:        classId,
+				AuthSource:     source,
+			}
+			return tx.Create(&user).Error
+		}
+
+		// a local account can not be taken over by a directory user with the same name
+		if user.AuthSource != source {
+			return ErrAuthSourceMismatch
+		}
+
+		if identity.Name != "" {
+			user.Name = identity.Name
+		}
+		if mapped {
+			user.ClassID = classId
+			user.RoleID = roleId
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"name":     user.Name,
+			"class_id": user.ClassID,
+			"role_id":  user.RoleID,
+		}).Error
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// localBackend verify the password stored in the users table, a password stored
+// with an outdated scheme is rehashed with the configured one after successful login
+type localBackend struct{}
+
+func (localBackend) Name() string {
+	return SourceLocal
+}
+
+func (localBackend) Authenticate(username, password string) (Identity, error) {
+	var user User
+	if err := DB.Where("username = ? AND auth_source = ?", username, SourceLocal).First(&user).Error; err != nil {
+		return Identity{}, ErrNotFound
+	}
+
+	if !verifyPassword(password, user.Password, user.PasswordScheme) {
+		return Identity{}, ErrInvalidPassword
+	}
+
+	// temporary password from an admin reset can only be used until it expires
+	if user.TempPasswordExpiresAt != nil && time.Now().After(*user.TempPasswordExpiresAt) {
+		return Identity{}, ErrTokenExpired
+	}
+
+	if needsRehash(user.Password, user.PasswordScheme) {
+		// a failed rehash must not block the login, it is retried next time
+		if hashedPassword, scheme, err := hashPassword(password); err == nil {
+			DB.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+				"password":        hashedPassword,
+				"password_scheme": scheme,
+			})
+		}
+	}
+
+	return Identity{UserID: user.ID, Username: user.Username, Name: user.Name}, nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import "testing"
+
+// TestGroupMapping verifies parsing of the group map and the first match winning
+func TestGroupMapping(t *testing.T) {
+	defer func(mappings []GroupMapping) { groupMappings = mappings }(groupMappings)
+
+	mappings, err := parseGroupMap("teachers:TI-1A:instructor, students:TI-1A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings[1].Role != RoleStudent || mappings[0].Class != "TI-1A" {
+		t.Fatalf("unexpected mappings %+v", mappings)
+	}
+	groupMappings = mappings
+
+	mapping, ok := matchGroup([]string{"Students", "teachers"})
+	if !ok || mapping.Role != RoleInstructor {
+		t.Errorf("expected instructor mapping, got %+v", mapping)
+	}
+	if _, ok := matchGroup([]string{"staff"}); ok {
+		t.Error("unmapped group should not match")
+	}
+
+	for _, invalid := range []string{"teachers", ":TI-1A", "a:b:c:d"} {
+		if _, err := parseGroupMap(invalid); err == nil {
+			t.Errorf("%q should be refused", invalid)
+		}
+	}
+}
+
+// TestLDAPGroupName verifies group DNs are reduced to their first RDN value
+func TestLDAPGroupName(t *testing.T) {
+	cases := map[string]string{
+		"cn=teachers,ou=groups,dc=school,dc=id": "teachers",
+		"students":                              "students",
+	}
+
+	for group, expected := range cases {
+		if name := groupName(group); name != expected {
+			t.Errorf("%s: expected %s, got %s", group, expected, name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+)
+
+// statuses of a record of a bulk push
+const (
+	BulkUpdated    = "updated"
+	BulkNotUpdated = "not_updated"
+	BulkFailed     = "failed"
+	BulkSkipped    = "skipped"
+)
+
+var (
+	ErrBulkTooLarge = errors.New("too many records")
+	// errBulkRollback abort the transaction of a failed atomic or dry run bulk push
+	errBulkRollback = errors.New("bulk rollback")
+)
+
+// BulkMaxRecords is the maximum number of records of a bulk push
+const BulkMaxRecords = 5000
+
+// BulkOptions is how a bulk push is processed
+type BulkOptions struct {
+	// Partial commit every valid record, otherwise a single failure roll back all of them
+	Partial bool
+	// DryRun process every record and roll back
+	DryRun bool
+	// UserID is the admin pushing the scores, APIKeyID the service account when not nil
+	UserID   int
+	APIKeyID *int
+}
+
+// BulkResult is the outcome of a single record of a bulk push
+type BulkResult struct {
+	Index    int    `json:"index"`
+	Username string `json:"username"`
+	Lab      string `json:"lab"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PushScores is a function to push many scores in a single transaction, each
+// record is checked like PushScoreByAPIKey, committed reports whether scores were saved
+func PushScores(scores []ScorePush, options BulkOptions) (results []BulkResult, committed bool, err error) {
+	if len(scores) > BulkMaxRecords {
+		return nil, false, ErrBulkTooLarge
+	}
+
+	results = make([]BulkResult, len(scores))
+	for i, score := range scores {
+		results[i] = BulkResult{Index: i, Username: score.Username, Lab: score.Lab, Status: BulkSkipped}
+	}
+
+	var failed bool
+	var updated []scoreRef
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for i, score := range scores {
+			var ref scoreRef
+			var changed bool
+			var err error
+			if options.Partial {
+				// a savepoint per record keep the others when it fails
+				err = tx.Transaction(func(savepoint *gorm.DB) error {
+					ref, changed, err = pushBulkScore(savepoint, score, options)
+					return err
+				})
+			} else {
+				ref, changed, err = pushBulkScore(tx, score, options)
+			}
+
+			if err != nil {
+				failed = true
+				results[i].Status = BulkFailed
+				results[i].Error = err.Error()
+				if !options.Partial {
+					return errBulkRollback
+				}
+				continue
+			}
+
+			results[i].Status = BulkNotUpdated
+			if changed {
+				results[i].Status = BulkUpdated
+				updated = append(updated, ref)
+			}
+		}
+
+		if options.DryRun {
+			return errBulkRollback
+		}
+		return nil
+	})
+	if err != nil && err != errBulkRollback {
+		return nil, false, err
+	}
+
+	committed = !options.DryRun && (options.Partial || !failed)
+	if committed {
+		publishScores(ScoreSourceBulk, updated...)
+	}
+	return results, committed, nil
+}
+
+// pushBulkScore check and record a single record of a bulk push inside tx
+func pushBulkScore(tx *gorm.DB, score ScorePush, options BulkOptions) (scoreRef, bool, error) {
+	user, lab, err := lookupScorePush(tx, score)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return scoreRef{}, false, ErrNotFound
+		}
+		return scoreRef{}, false, err
+	}
+
+	if options.APIKeyID != nil {
+		allowed, err := apiKeyAllowsCourse(tx, *options.APIKeyID, lab.CourseID)
+		if err != nil {
+			return scoreRef{}, false, err
+		}
+		if !allowed {
+			return scoreRef{}, false, ErrCourseNotAllowed
+		}
+		if user.Role.Name != RoleStudent {
+			return scoreRef{}, false, ErrUnauthorized
+		}
+	} else {
+		allowed, err := HasPermission(options.UserID, PermScoresPush, Scope{UserID: user.ID, CourseID: lab.CourseID})
+		if err != nil {
+			return scoreRef{}, false, err
+		}
+		if !allowed {
+			return scoreRef{}, false, ErrUnauthorized
+		}
+	}
+
+	changed, err := recordAttemptTx(tx, user.ID, lab.ID, score, options.APIKeyID)
+	return scoreRef{user.ID, lab.ID}, changed, err
+}
@@ -0,0 +1,97 @@
+//go:build cgo
+
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// countScores returns the number of scores and attempts saved
+func countScores(t *testing.T) (scores int64, attempts int64) {
+	if err := DB.Model(&Score{}).Count(&scores).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Model(&Attempt{}).Count(&attempts).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return scores, attempts
+}
+
+// TestPushScoresModes verifies a failed record roll back an atomic push, partial pushes keep the
+// valid records with a savepoint each, and dry runs save nothing
+func TestPushScoresModes(t *testing.T) {
+	openTestDB(t)
+	class, _, _ := createTestLab(t, "XII TKJ 1", "Linux", "lab-1")
+	admin := createTestUser(t, "admin", RoleAdmin, class.ID)
+	createTestUser(t, "student1", RoleStudent, class.ID)
+	createTestUser(t, "student2", RoleStudent, class.ID)
+
+	records := []ScorePush{
+		{Username: "student1", Lab: "lab-1", Score: 60},
+		{Username: "unknown", Lab: "lab-1", Score: 70},
+		{Username: "student2", Lab: "lab-1", Score: 80},
+	}
+
+	results, committed, err := PushScores(records, BulkOptions{UserID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed || results[1].Status != BulkFailed || results[2].Status != BulkSkipped {
+		t.Errorf("atomic: expected a rollback at the failed record, got %v %+v", committed, results)
+	}
+	if scores, attempts := countScores(t); scores != 0 || attempts != 0 {
+		t.Errorf("atomic: expected nothing saved, got %d scores and %d attempts", scores, attempts)
+	}
+
+	results, committed, err = PushScores(records, BulkOptions{UserID: admin.ID, DryRun: true, Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed || results[0].Status != BulkUpdated || results[1].Status != BulkFailed || results[2].Status != BulkUpdated {
+		t.Errorf("dry run: expected every record processed, got %v %+v", committed, results)
+	}
+	if scores, attempts := countScores(t); scores != 0 || attempts != 0 {
+		t.Errorf("dry run: expected nothing saved, got %d scores and %d attempts", scores, attempts)
+	}
+
+	results, committed, err = PushScores(records, BulkOptions{UserID: admin.ID, Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed || results[0].Status != BulkUpdated || results[1].Status != BulkFailed || results[2].Status != BulkUpdated {
+		t.Errorf("partial: expected the valid records saved, got %v %+v", committed, results)
+	}
+	if scores, attempts := countScores(t); scores != 2 || attempts != 2 {
+		t.Errorf("partial: expected 2 scores and 2 attempts, got %d and %d", scores, attempts)
+	}
+}
+
+// TestPushScoresClosedLab verifies bulk records can not be dated before the lab closed
+func TestPushScoresClosedLab(t *testing.T) {
+	openTestDB(t)
+	class, _, lab := createTestLab(t, "XII TKJ 1", "Linux", "lab-1")
+	admin := createTestUser(t, "admin", RoleAdmin, class.ID)
+	createTestUser(t, "student1", RoleStudent, class.ID)
+
+	closed := time.Now().Add(-24 * time.Hour)
+	if err := DB.Model(&lab).Update("closes_at", closed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var records []ScorePush
+	body := `[{"username":"student1","lab":"lab-1","score":90,"submitted_at":"` + closed.Add(-time.Hour).Format(time.RFC3339) + `"}]`
+	if err := json.Unmarshal([]byte(body), &records); err != nil {
+		t.Fatal(err)
+	}
+
+	results, committed, err := PushScores(records, BulkOptions{UserID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed || results[0].Status != BulkFailed {
+		t.Errorf("expected the record refused after closes_at, got %v %+v", committed, results)
+	}
+}
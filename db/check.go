@@ -0,0 +1,71 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"math"
+)
+
+// validateChecks check names are unique and points of checks sum to score, checks are optional
+func validateChecks(score float64, checks []AttemptCheck) error {
+	if len(checks) == 0 {
+		return nil
+	}
+
+	names := map[string]bool{}
+	var sum float64
+	for _, check := range checks {
+		if check.Name == "" || names[check.Name] {
+			return ErrInvalidChecks
+		}
+		if check.Points < 0 || check.MaxPoints < 0 || check.Points > check.MaxPoints {
+			return ErrInvalidChecks
+		}
+		names[check.Name] = true
+		sum += check.Points
+	}
+
+	// decimal points do not add up exactly as floats
+	if math.Abs(sum-score) > 0.001 {
+		return ErrChecksMismatch
+	}
+
+	return nil
+}
+
+// createChecks save checks of attemptId
+func createChecks(tx *gorm.DB, attemptId int, checks []AttemptCheck) error {
+	for _, check := range checks {
+		check.ID = 0
+		check.AttemptID = attemptId
+		if err := tx.Create(&check).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetCheckSummary is a function to summarize the checks behind the scores of
+// students of classId on labs of courseId
+func GetCheckSummary(courseId int, classId int) ([]CheckSummary, error) {
+	var summary []CheckSummary
+
+	res := DB.Table("attempt_checks").
+		Select(`labs.name AS lab, attempt_checks.name,
+			SUM(CASE WHEN attempt_checks.passed THEN 1 ELSE 0 END) AS passed,
+			COUNT(*) AS total,
+			AVG(attempt_checks.points) AS average_points,
+			MAX(attempt_checks.max_points) AS max_points`).
+		Joins("JOIN scores ON scores.attempt_id = attempt_checks.attempt_id").
+		Joins("JOIN labs ON labs.id = scores.lab_id").
+		Joins("JOIN users ON users.id = scores.user_id").
+		Where("labs.course_id = ? AND users.class_id = ?", courseId, classId).
+		Group("labs.id, labs.name, attempt_checks.name").
+		Order("labs.id, attempt_checks.name").
+		Scan(&summary)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return summary, nil
+}
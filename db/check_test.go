@@ -0,0 +1,50 @@
+package db
+
+import "testing"
+
+// TestValidateChecks verifies checks must have unique names and sum to the score
+func TestValidateChecks(t *testing.T) {
+	checks := []AttemptCheck{
+		{Name: "service running", Passed: true, Points: 40, MaxPoints: 40},
+		{Name: "port open", Passed: false, Points: 20, MaxPoints: 60},
+	}
+
+	if err := validateChecks(60, checks); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := validateChecks(70, checks); err != ErrChecksMismatch {
+		t.Errorf("expected ErrChecksMismatch, got %v", err)
+	}
+	if err := validateChecks(80, append(checks, AttemptCheck{Name: "port open", Points: 20, MaxPoints: 20})); err != ErrInvalidChecks {
+		t.Errorf("duplicate name: expected ErrInvalidChecks, got %v", err)
+	}
+	if err := validateChecks(50, []AttemptCheck{{Name: "too much", Points: 50, MaxPoints: 40}}); err != ErrInvalidChecks {
+		t.Errorf("points over max: expected ErrInvalidChecks, got %v", err)
+	}
+	if err := validateChecks(60, nil); err != nil {
+		t.Errorf("without checks: expected no error, got %v", err)
+	}
+}
+
+// TestValidateScore verifies scores are checked against the max score of the lab
+func TestValidateScore(t *testing.T) {
+	tests := []struct {
+		lab   Lab
+		score float64
+		valid bool
+	}{
+		{Lab{MaxScore: 100}, 100, true},
+		{Lab{MaxScore: 10}, 11, false},
+		{Lab{MaxScore: 250}, 180, true},
+		{Lab{MaxScore: 10}, 7.5, false},
+		{Lab{MaxScore: 10, AllowFraction: true}, 7.5, true},
+		{Lab{MaxScore: 10, AllowFraction: true}, 7.125, false},
+		{Lab{MaxScore: 10}, -1, false},
+	}
+
+	for _, test := range tests {
+		if err := validateScore(test.lab, test.score); (err == nil) != test.valid {
+			t.Errorf("%v out of %v: expected valid %v, got %v", test.score, test.lab.MaxScore, test.valid, err)
+		}
+	}
+}
@@ -2,19 +2,27 @@ package db
 
 import (
 	"errors"
+	"gorm.io/gorm"
+	"time"
 )
 
 var (
-	ErrAlreadyExist = errors.New("already exist")
-	ErrCantBeEmpty  = errors.New("cannot be empty")
-	ErrScoreInvalid = errors.New("score invalid")
-	ErrNotFound     = errors.New("not found")
-	ErrUnauthorized = errors.New("unauthorized")
-	ScoreUpdated    = errors.New("updated")
-	ScoreNotUpdated = errors.New("keep highest score")
+	ErrAlreadyExist     = errors.New("already exist")
+	ErrCantBeEmpty      = errors.New("cannot be empty")
+	ErrScoreInvalid     = errors.New("score invalid")
+	ErrNotFound         = errors.New("not found")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenRevoked     = errors.New("token revoked")
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrPasswordTooShort = errors.New("password too short")
+	ScoreUpdated        = errors.New("updated")
+	ScoreNotUpdated     = errors.New("score not updated")
+	ErrInvalidChecks    = errors.New("invalid checks")
+	ErrChecksMismatch   = errors.New("checks points do not sum to score")
 )
 
-// CreateUser is a function to create a user with hashed and salted password
+// CreateUser is a function to create a user with hashed password
 func CreateUser(user User) (result User, err error) {
 	if user.Name == "" || user.Username == "" || user.Password == "" || user.ClassID == 0 {
 		return result, ErrCantBeEmpty
@@ -26,11 +34,12 @@ func CreateUser(user User) (result User, err error) {
 
 	// check if a username record exists in the table
 	if err := DB.Where("username = ?", user.Username).First(&user).Error; err != nil {
-		hashedPassword, err := hashAndSaltPassword(user.Password)
+		hashedPassword, scheme, err := hashPassword(user.Password)
 		if err != nil {
 			return result, err
 		}
 		user.Password = hashedPassword
+		user.PasswordScheme = scheme
 
 		res := DB.Create(&user)
 		if res.Error != nil {
@@ -54,27 +63,27 @@ func GetUsersByClassId(classId int) (users []Student, err error) {
 	return users, nil
 }
 
-// UpdateUsersByUsersId is a function to update user based on userId
-func UpdateUsersByUsersId(userId int, user User) error {
-	if user.Name == "" || user.Username == "" || user.ClassID == 0 || user.RoleID == 0 {
-		return ErrCantBeEmpty
+// GetUserById is a function to get user based on userId
+func GetUserById(userId int) (user User, err error) {
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return user, ErrNotFound
 	}
 
-	var oldUser User
-
-	if err := DB.Where("id = ?", userId).First(&oldUser).Error; err != nil {
-		return ErrNotFound
-	}
+	return user, nil
+}
 
-	res := DB.Table("users").Where("id = ?", userId).Updates(user)
-	if res.Error != nil {
-		return res.Error
+// UpdateUsersByUsersId is a function to update user based on userId,
+// a new password is hashed before it is stored
+func UpdateUsersByUsersId(userId int, user User) error {
+	if user.Name == "" || user.Username == "" || user.ClassID == 0 || user.RoleID == 0 {
+		return ErrCantBeEmpty
 	}
 
-	return nil
+	return PatchUsersByUsersId(userId, user)
 }
 
-// PatchUsersByUsersId is a function to patch user based on userId
+// PatchUsersByUsersId is a function to patch user based on userId, a password set
+// by an admin revoke the sessions of the user and must be changed on next login
 func PatchUsersByUsersId(userId int, user User) error {
 	var oldUser User
 
@@ -82,12 +91,26 @@ func PatchUsersByUsersId(userId int, user User) error {
 		return ErrNotFound
 	}
 
-	res := DB.Table("users").Where("id = ?", userId).Updates(user)
-	if res.Error != nil {
-		return res.Error
+	if user.Password == "" {
+		return DB.Table("users").Where("id = ?", userId).Updates(user).Error
 	}
 
-	return nil
+	hashedPassword, scheme, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	user.PasswordScheme = scheme
+	user.MustChangePassword = true
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Table("users").Where("id = ?", userId).Updates(user)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		return revokeUserSessions(tx, userId)
+	})
 }
 
 // DeleteUsersByUsersId is a function to delete user based on userId
@@ -190,6 +213,14 @@ func CreateCourse(course Course) (result Course, err error) {
 	if course.Name == "" {
 		return result, ErrCantBeEmpty
 	}
+	if err := validatePolicy(course.ScoringPolicy, course.PolicyN); err != nil {
+		return result, err
+	}
+	if course.GradeScale != "" {
+		if _, err := parseGradeScale(course.GradeScale); err != nil {
+			return result, err
+		}
+	}
 
 	// check if a course name record exists in the table
 	if err := DB.Where("name = ?", course.Name).First(&course).Error; err != nil {
@@ -229,6 +260,14 @@ func UpdateCourseByCourseId(courseId int, course Course) error {
 	if course.Name == "" {
 		return ErrCantBeEmpty
 	}
+	if err := validatePolicy(course.ScoringPolicy, course.PolicyN); err != nil {
+		return err
+	}
+	if course.GradeScale != "" {
+		if _, err := parseGradeScale(course.GradeScale); err != nil {
+			return err
+		}
+	}
 
 	var oldCourse Course
 
@@ -236,12 +275,32 @@ func UpdateCourseByCourseId(courseId int, course Course) error {
 		return ErrNotFound
 	}
 
-	res := DB.Model(&course).Where("id = ?", courseId).Updates(course)
-	if res.Error != nil {
-		return res.Error
-	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// an empty policy is written too, the course then use the configured default
+		res := tx.Model(&Course{}).Where("id = ?", courseId).Updates(map[string]interface{}{
+			"name":              course.Name,
+			"scoring_policy":    course.ScoringPolicy,
+			"policy_n":          course.PolicyN,
+			"require_signature": course.RequireSignature,
+			"grade_scale":       course.GradeScale,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
 
-	return nil
+		if oldCourse.ScoringPolicy == course.ScoringPolicy && oldCourse.PolicyN == course.PolicyN {
+			return nil
+		}
+
+		var labIds []int
+		if err := tx.Model(&Lab{}).Where("course_id = ?", courseId).Pluck("id", &labIds).Error; err != nil {
+			return err
+		}
+		if len(labIds) == 0 {
+			return nil
+		}
+		return recomputeLabScores(tx, labIds)
+	})
 }
 
 // DeleteCourseByCourseId is a function to delete course based on courseId
@@ -266,6 +325,18 @@ func CreateLab(lab Lab) (result Lab, err error) {
 	if lab.Name == "" {
 		return result, ErrCantBeEmpty
 	}
+	if err := validatePolicy(lab.ScoringPolicy, lab.PolicyN); err != nil {
+		return result, err
+	}
+	if err := validateLatePenalty(lab); err != nil {
+		return result, err
+	}
+	if lab.Weight < 0 {
+		return result, ErrInvalidWeight
+	}
+	if lab.MaxScore < 0 {
+		return result, ErrScoreInvalid
+	}
 
 	// check if a lab name record exists in the table
 	if err := DB.Where("name = ?", lab.Name).First(&lab).Error; err != nil {
@@ -279,16 +350,57 @@ func CreateLab(lab Lab) (result Lab, err error) {
 	}
 }
 
-// GetLabs is a function to get all labs based on courseId
-func GetLabs(courseId string) (labs []GeneralData, err error) {
+// GetLabs is a function to get all labs based on courseId ordered by id, with the schedule of classId when not 0
+func GetLabs(courseId string, classId int) (labs []LabInfo, err error) {
 	if courseId == "" {
 		return labs, ErrCantBeEmpty
 	}
-	res := DB.Table("labs").Where("course_id = ?", courseId).Select("id, name").Find(&labs)
+
+	var rows []Lab
+	res := DB.Preload("Course").Where("course_id = ?", courseId).Order("id").Find(&rows)
 	if res.Error != nil {
 		return nil, res.Error
 	}
 
+	// schedules of the class replace the dates of the labs, loaded at once
+	var schedules []LabSchedule
+	if classId != 0 && len(rows) > 0 {
+		res = DB.Where("class_id = ? AND lab_id IN (?)", classId, DB.Model(&Lab{}).Select("id").Where("course_id = ?", courseId)).
+			Find(&schedules)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+	}
+	windows := map[int]labWindow{}
+	for _, schedule := range schedules {
+		windows[schedule.LabID] = labWindow{schedule.OpensAt, schedule.DueAt, schedule.ClosesAt}
+	}
+
+	for _, lab := range rows {
+		policy, n := resolvePolicy(lab, lab.Course)
+		window, ok := windows[lab.ID]
+		if !ok {
+			window = labWindow{lab.OpensAt, lab.DueAt, lab.ClosesAt}
+		}
+		info := LabInfo{
+			ID:            lab.ID,
+			Name:          lab.Name,
+			ScoringPolicy: policy,
+			OpensAt:       window.OpensAt,
+			DueAt:         window.DueAt,
+			ClosesAt:      window.ClosesAt,
+			MaxScore:      lab.MaxScore,
+			AllowFraction: lab.AllowFraction,
+			Category:      lab.Category,
+			Weight:        lab.Weight,
+			ExtraCredit:   lab.ExtraCredit,
+		}
+		if policy == PolicyBestOfN {
+			info.PolicyN = n
+		}
+		labs = append(labs, info)
+	}
+
 	return labs, nil
 }
 
@@ -297,6 +409,22 @@ func UpdateLabByLabId(labId int, lab Lab) error {
 	if lab.Name == "" {
 		return ErrCantBeEmpty
 	}
+	if err := validatePolicy(lab.ScoringPolicy, lab.PolicyN); err != nil {
+		return err
+	}
+	if err := validateLatePenalty(lab); err != nil {
+		return err
+	}
+	if lab.Weight < 0 {
+		return ErrInvalidWeight
+	}
+	if lab.MaxScore < 0 {
+		return ErrScoreInvalid
+	}
+	// unset max score keep the usual 0 to 100 range
+	if lab.MaxScore == 0 {
+		lab.MaxScore = 100
+	}
 
 	var oldLab Lab
 
@@ -304,12 +432,37 @@ func UpdateLabByLabId(labId int, lab Lab) error {
 		return ErrNotFound
 	}
 
-	res := DB.Model(&lab).Where("id = ?", labId).Updates(lab)
-	if res.Error != nil {
-		return res.Error
-	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// empty policy and schedule are written too, the lab then use the policy of its course
+		// and has no deadline
+		res := tx.Model(&lab).Where("id = ?", labId).Updates(lab)
+		if res.Error != nil {
+			return res.Error
+		}
+		res = tx.Model(&Lab{}).Where("id = ?", labId).Updates(map[string]interface{}{
+			"scoring_policy":       lab.ScoringPolicy,
+			"policy_n":             lab.PolicyN,
+			"opens_at":             lab.OpensAt,
+			"due_at":               lab.DueAt,
+			"closes_at":            lab.ClosesAt,
+			"late_penalty_per_day": lab.LatePenaltyPerDay,
+			"late_penalty_cap":     lab.LatePenaltyCap,
+			"late_grace_minutes":   lab.LateGraceMinutes,
+			"category":             lab.Category,
+			"weight":               lab.Weight,
+			"extra_credit":         lab.ExtraCredit,
+			"max_score":            lab.MaxScore,
+			"allow_fraction":       lab.AllowFraction,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
 
-	return nil
+		if !scoringChanged(oldLab, lab) {
+			return nil
+		}
+		return recomputeLabScores(tx, []int{labId})
+	})
 }
 
 // DeleteLabByLabId is a function to delete lab based on labId
@@ -331,62 +484,88 @@ func DeleteLabByLabId(labId int) error {
 
 // PushScore is a function to push score to database
 func PushScore(userId int, score ScorePush) error {
-	if score.Username == "" || score.Lab == "" {
-		return ErrCantBeEmpty
+	user, lab, err := lookupScorePush(DB, score)
+	if err != nil {
+		return err
+	}
+
+	// check if user_id and userId are the same
+	if user.ID != userId {
+		return ErrUnauthorized
 	}
 
-	// check if score is higher than 100
-	if score.Score > 100 || score.Score < 0 {
+	// students push from their own machine, the grading client signs the submission
+	if err := verifyScoreSignature(lab, score, time.Now()); err != nil {
+		return err
+	}
+
+	return recordAttempt(user.ID, lab.ID, score, nil)
+}
+
+// PushScoreByAPIKey is a function to push score of any student with an api key
+// allowed on the course of the lab
+func PushScoreByAPIKey(apiKeyId int, score ScorePush) error {
+	user, lab, err := lookupScorePush(DB, score)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := apiKeyAllowsCourse(DB, apiKeyId, lab.CourseID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrCourseNotAllowed
+	}
+
+	// api keys only push scores of students
+	if user.Role.Name != RoleStudent {
+		return ErrUnauthorized
+	}
+
+	return recordAttempt(user.ID, lab.ID, score, &apiKeyId)
+}
+
+// validateScore check score is between 0 and the max score of lab, whole unless
+// the lab allow fractions, with at most two decimals
+func validateScore(lab Lab, score float64) error {
+	if score < 0 || score > lab.MaxScore {
+		return ErrScoreInvalid
+	}
+	if roundScore(score, lab.AllowFraction) != score {
 		return ErrScoreInvalid
 	}
 
-	// lookup lab id by lab name
-	var lab Lab
-	res := DB.Where("name = ?", score.Lab).First(&lab)
-	if res.Error != nil {
-		return res.Error
+	return nil
+}
+
+// lookupScorePush validate score and lookup its user and lab
+func lookupScorePush(tx *gorm.DB, score ScorePush) (user User, lab Lab, err error) {
+	if score.Username == "" || score.Lab == "" {
+		return user, lab, ErrCantBeEmpty
 	}
 
-	// lookup user id by username
-	var user User
-	res = DB.Where("username = ?", score.Username).First(&user)
+	// lookup lab id by lab name
+	res := tx.Where("name = ?", score.Lab).First(&lab)
 	if res.Error != nil {
-		return res.Error
+		return user, lab, res.Error
 	}
 
-	// check if user_id and userId are the same
-	if user.ID != userId {
-		return ErrUnauthorized
+	if err := validateScore(lab, score.Score); err != nil {
+		return user, lab, err
 	}
 
-	// check if a score record exists in the table
-	var scores Score
-	if err := DB.Where("user_id = ? AND lab_id = ?", user.ID, lab.ID).First(&scores).Error; err != nil {
-		// create new score record
-		scores = Score{
-			UserID: user.ID,
-			LabID:  lab.ID,
-			Score:  score.Score,
-		}
-		res := DB.Create(&scores)
-		if res.Error != nil {
-			return res.Error
-		}
-		return ScoreUpdated
+	if err := validateChecks(score.Score, score.Checks); err != nil {
+		return user, lab, err
+	}
 
-	} else {
-		// update score record if score is higher
-		if scores.Score < score.Score {
-			scores.Score = score.Score
-			res := DB.Save(&scores)
-			if res.Error != nil {
-				return res.Error
-			}
-			return ScoreUpdated
-		}
+	// lookup user id by username
+	res = tx.Preload("Role").Where("username = ?", score.Username).First(&user)
+	if res.Error != nil {
+		return user, lab, res.Error
 	}
 
-	return ScoreNotUpdated
+	return user, lab, nil
 }
 
 // GetScoreByLabName get score by lab name
@@ -409,17 +588,32 @@ func GetScoreByLabName(userId int, labName string) (labScore ScoreLab, err error
 		return labScore, ErrNotFound
 	}
 
-	return ScoreLab{
-		LabName: labName,
-		Score:   scores.Score,
-	}, nil
+	labScore = ScoreLab{
+		UserID:         userId,
+		LabID:          lab.ID,
+		LabName:        labName,
+		Score:          scores.Score,
+		Penalty:        scores.Penalty,
+		OverrideAction: scores.OverrideAction,
+		OverrideReason: scores.OverrideReason,
+	}
+
+	// checks of the attempt the score comes from
+	if scores.AttemptID != nil {
+		res = DB.Where("attempt_id = ?", *scores.AttemptID).Order("id").Find(&labScore.Checks)
+		if res.Error != nil {
+			return labScore, res.Error
+		}
+	}
+
+	return labScore, nil
 }
 
 // ExportScores get score by course id and class id
 func ExportScores(userId int, courseId int, classId int) (scores []ScoreLab, err error) {
 	// join the score table with the labs table using the course_id foreign key
 	// then join the labs table with the class table using the class_id foreign key
-	query := DB.Table("scores").Select("labs.name, scores.score")
+	query := DB.Table("scores").Select("scores.user_id, scores.lab_id, labs.name, scores.score, scores.penalty, scores.override_action, scores.override_reason")
 	query = query.Joins("JOIN labs ON labs.id = scores.lab_id").Joins("JOIN users ON users.id = scores.user_id")
 	query = query.Where("users.id = ? AND labs.course_id = ? AND users.class_id = ?", userId, courseId, classId)
 
@@ -430,3 +624,25 @@ func ExportScores(userId int, courseId int, classId int) (scores []ScoreLab, err
 
 	return scores, nil
 }
+
+// ExportClassScores is a function to get the scores of every student of classId on the labs
+// of courseId in a single query, by user id
+func ExportClassScores(courseId int, classId int) (map[int][]ScoreLab, error) {
+	var rows []ScoreLab
+	res := DB.Table("scores").
+		Select("scores.user_id, scores.lab_id, labs.name, scores.score, scores.penalty, scores.override_action, scores.override_reason").
+		Joins("JOIN labs ON labs.id = scores.lab_id").
+		Joins("JOIN users ON users.id = scores.user_id").
+		Where("labs.course_id = ? AND users.class_id = ?", courseId, classId).
+		Find(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	scores := map[int][]ScoreLab{}
+	for _, row := range rows {
+		scores[row.UserID] = append(scores[row.UserID], row)
+	}
+
+	return scores, nil
+}
@@ -19,6 +19,12 @@ type Config struct {
 // DB is a global database connection pool
 var DB *gorm.DB
 
+// models is the schema created by the migration
+var models = []interface{}{&User{}, &Course{}, &Lab{}, &Score{}, &RefreshToken{}, &RevokedToken{}, &LoginAttempt{},
+	&Role{}, &Permission{}, &ClassAssignment{}, &APIKey{}, &RecoveryCode{}, &Attempt{}, &LabSchedule{},
+	&AttemptCheck{}, &ScoreNonce{}, &IdempotencyRecord{},
+	&ScoreOverride{}, &GradeCategory{}, &Artifact{}}
+
 func (config Config) InitDB(migrate bool) error {
 	var err error
 
@@ -35,8 +41,26 @@ func (config Config) InitDB(migrate bool) error {
 	}
 
 	if migrate {
+		// a student has a single score per lab, duplicates are removed before the unique index is created
+		err = dedupeScores()
+		if err != nil {
+			return err
+		}
+
 		// Auto-migrate the database schema
-		err = DB.AutoMigrate(&User{}, &Course{}, &Lab{}, &Score{})
+		err = DB.AutoMigrate(models...)
+		if err != nil {
+			return err
+		}
+
+		// create default roles with their permissions
+		err = seedRoles()
+		if err != nil {
+			return err
+		}
+
+		// keep scores pushed before attempts were recorded
+		err = seedLegacyAttempts()
 		if err != nil {
 			return err
 		}
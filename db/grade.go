@@ -0,0 +1,279 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidGradeScale = errors.New("invalid grade scale")
+	ErrInvalidCategory   = errors.New("invalid grade category")
+	ErrInvalidWeight     = errors.New("invalid lab weight")
+)
+
+// GradingConfig is a struct to store course grade configuration
+type GradingConfig struct {
+	// Scale is the default grade scale of courses, letters with their minimum
+	// percentage like A:90,B:80,C:70,D:60,E:0
+	Scale string
+	// PassPercentage is the percentage of the max score a lab is passed with, default 100
+	PassPercentage float64
+}
+
+// GradeStep is a letter of a grade scale with its minimum percentage
+type GradeStep struct {
+	Letter  string
+	Minimum float64
+}
+
+var defaultGradeScale = []GradeStep{{"A", 90}, {"B", 80}, {"C", 70}, {"D", 60}, {"E", 0}}
+
+var gradeScale = defaultGradeScale
+
+var passPercentage float64 = 100
+
+// InitGrading set the grading configuration, an empty scale or a pass percentage
+// out of 0 to 100 keep the default
+func (config GradingConfig) InitGrading() error {
+	passPercentage = 100
+	if config.PassPercentage > 0 && config.PassPercentage <= 100 {
+		passPercentage = config.PassPercentage
+	}
+
+	if config.Scale == "" {
+		gradeScale = defaultGradeScale
+		return nil
+	}
+
+	scale, err := parseGradeScale(config.Scale)
+	if err != nil {
+		return err
+	}
+
+	gradeScale = scale
+	return nil
+}
+
+// LabPassed check if a lab scored percentage of its max score is passed
+func LabPassed(percentage float64) bool {
+	return percentage >= passPercentage
+}
+
+// parseGradeScale read letters with their minimum percentage, sorted from the highest minimum
+func parseGradeScale(value string) ([]GradeStep, error) {
+	var scale []GradeStep
+	for _, step := range strings.Split(value, ",") {
+		letter, minimum, ok := strings.Cut(strings.TrimSpace(step), ":")
+		if !ok || strings.TrimSpace(letter) == "" {
+			return nil, ErrInvalidGradeScale
+		}
+		min, err := strconv.ParseFloat(strings.TrimSpace(minimum), 64)
+		if err != nil || min < 0 || min > 100 {
+			return nil, ErrInvalidGradeScale
+		}
+		scale = append(scale, GradeStep{strings.TrimSpace(letter), min})
+	}
+
+	sort.SliceStable(scale, func(i, j int) bool { return scale[i].Minimum > scale[j].Minimum })
+	return scale, nil
+}
+
+// letterGrade returns the letter of percentage, empty when below every minimum
+func letterGrade(scale []GradeStep, percentage float64) string {
+	for _, step := range scale {
+		if percentage >= step.Minimum {
+			return step.Letter
+		}
+	}
+
+	return ""
+}
+
+// CategoryGrade is the subtotal of a category of labs
+type CategoryGrade struct {
+	Name       string   `json:"name"`
+	Weight     float64  `json:"weight"`
+	Percentage float64  `json:"percentage"`
+	Dropped    []string `json:"dropped,omitempty"`
+}
+
+// GradedLab is a lab score with the grading settings of the lab
+type GradedLab struct {
+	Name        string
+	Category    string
+	Weight      float64
+	ExtraCredit bool
+	Score       float64
+	MaxScore    float64
+}
+
+// percentage returns the score of lab out of 100
+func (lab GradedLab) percentage() float64 {
+	if lab.MaxScore <= 0 {
+		return lab.Score
+	}
+	return lab.Score / lab.MaxScore * 100
+}
+
+// ComputeGrade is a function to compute the weighted percentage of labs, each scored out of its max score, with the
+// categories of a course, without categories every lab is in a single category
+// weighted by lab weight, extra credit labs add weight percent of their score on top
+func ComputeGrade(labs []GradedLab, categories []GradeCategory, scale []GradeStep) (subtotals []CategoryGrade, percentage float64, letter string) {
+	if len(scale) == 0 {
+		scale = gradeScale
+	}
+
+	// keep the order of the scheme, then categories without scheme in lab order
+	byName := map[string][]GradedLab{}
+	var names []string
+	for _, category := range categories {
+		names = append(names, category.Name)
+		byName[category.Name] = nil
+	}
+	var extra []GradedLab
+	for _, lab := range labs {
+		if lab.ExtraCredit {
+			extra = append(extra, lab)
+			continue
+		}
+		if _, ok := byName[lab.Category]; !ok {
+			names = append(names, lab.Category)
+		}
+		byName[lab.Category] = append(byName[lab.Category], lab)
+	}
+
+	var weighted, weights float64
+	for _, name := range names {
+		categoryLabs := byName[name]
+		if len(categoryLabs) == 0 {
+			continue
+		}
+
+		weight, drop := 0.0, 0
+		for _, category := range categories {
+			if category.Name == name {
+				weight, drop = category.Weight, category.DropLowest
+			}
+		}
+		// without scheme the category is the whole course
+		if len(categories) == 0 {
+			weight = 1
+		}
+
+		subtotal, dropped := categoryPercentage(categoryLabs, drop)
+		subtotals = append(subtotals, CategoryGrade{Name: name, Weight: weight, Percentage: roundPercentage(subtotal), Dropped: dropped})
+
+		weighted += subtotal * weight
+		weights += weight
+	}
+
+	if weights > 0 {
+		percentage = weighted / weights
+	}
+	for _, lab := range extra {
+		percentage += lab.percentage() * labWeight(lab) / 100
+	}
+	if percentage > 100 {
+		percentage = 100
+	}
+
+	percentage = roundPercentage(percentage)
+	return subtotals, percentage, letterGrade(scale, percentage)
+}
+
+// categoryPercentage returns the weighted mean of labs without the drop lowest
+// scores, at least one lab is kept
+func categoryPercentage(labs []GradedLab, drop int) (float64, []string) {
+	sorted := make([]GradedLab, len(labs))
+	copy(sorted, labs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].percentage() < sorted[j].percentage() })
+
+	if drop >= len(sorted) {
+		drop = len(sorted) - 1
+	}
+	var dropped []string
+	for _, lab := range sorted[:drop] {
+		dropped = append(dropped, lab.Name)
+	}
+
+	var sum, weights float64
+	for _, lab := range sorted[drop:] {
+		sum += lab.percentage() * labWeight(lab)
+		weights += labWeight(lab)
+	}
+
+	return sum / weights, dropped
+}
+
+// labWeight returns the weight of lab, unset weights count as 1
+func labWeight(lab GradedLab) float64 {
+	if lab.Weight <= 0 {
+		return 1
+	}
+	return lab.Weight
+}
+
+func roundPercentage(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// GetGradeCategories is a function to get the grading scheme of courseId
+func GetGradeCategories(courseId int) ([]GradeCategory, error) {
+	var categories []GradeCategory
+	if err := DB.Where("course_id = ?", courseId).Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// SetGradeCategories is a function to replace the grading scheme of courseId
+func SetGradeCategories(courseId int, categories []GradeCategory) ([]GradeCategory, error) {
+	names := map[string]bool{}
+	for _, category := range categories {
+		if category.Name == "" || names[category.Name] || category.Weight < 0 || category.DropLowest < 0 {
+			return nil, ErrInvalidCategory
+		}
+		names[category.Name] = true
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", courseId).First(&Course{}).Error; err != nil {
+			return ErrNotFound
+		}
+		if err := tx.Where("course_id = ?", courseId).Delete(&GradeCategory{}).Error; err != nil {
+			return err
+		}
+
+		for i := range categories {
+			categories[i].ID = 0
+			categories[i].CourseID = courseId
+			if err := tx.Create(&categories[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// GetGradeScale is a function to get the grade scale of courseId, the configured one when the course has none
+func GetGradeScale(courseId int) ([]GradeStep, error) {
+	var course Course
+	if err := DB.Where("id = ?", courseId).First(&course).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	if course.GradeScale == "" {
+		return gradeScale, nil
+	}
+	return parseGradeScale(course.GradeScale)
+}
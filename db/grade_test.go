@@ -0,0 +1,55 @@
+package db
+
+import "testing"
+
+// TestComputeGrade verifies category weights, drop lowest and extra credit
+func TestComputeGrade(t *testing.T) {
+	labs := []GradedLab{
+		{Name: "week-1", Category: "weekly", Score: 50},
+		{Name: "week-2", Category: "weekly", Score: 80},
+		{Name: "week-3", Category: "weekly", Score: 90},
+		{Name: "project", Category: "project", Score: 70},
+		{Name: "bonus", ExtraCredit: true, Weight: 5, Score: 100},
+	}
+	categories := []GradeCategory{
+		{Name: "weekly", Weight: 60, DropLowest: 1},
+		{Name: "project", Weight: 40},
+	}
+
+	subtotals, percentage, letter := ComputeGrade(labs, categories, defaultGradeScale)
+
+	// weekly 85 without week-1, project 70, 85*0.6 + 70*0.4 = 79, plus 5 of extra credit
+	if len(subtotals) != 2 || subtotals[0].Percentage != 85 || subtotals[0].Dropped[0] != "week-1" {
+		t.Errorf("unexpected subtotals %+v", subtotals)
+	}
+	if percentage != 84 || letter != "B" {
+		t.Errorf("expected 84 B, got %v %s", percentage, letter)
+	}
+
+	// without scheme labs are averaged with their weight
+	weighted := []GradedLab{{Name: "a", Weight: 3, Score: 100}, {Name: "b", Score: 60}}
+	if _, percentage, _ := ComputeGrade(weighted, nil, defaultGradeScale); percentage != 90 {
+		t.Errorf("expected 90, got %v", percentage)
+	}
+
+	// labs of different max scores are compared as percentages
+	mixed := []GradedLab{{Name: "quiz", Score: 8, MaxScore: 10}, {Name: "exam", Score: 150, MaxScore: 250}}
+	if _, percentage, _ := ComputeGrade(mixed, nil, defaultGradeScale); percentage != 70 {
+		t.Errorf("expected 70, got %v", percentage)
+	}
+}
+
+// TestParseGradeScale verifies the scale is sorted and invalid steps are refused
+func TestParseGradeScale(t *testing.T) {
+	scale, err := parseGradeScale("F:0, A:85 ,B:70")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if letterGrade(scale, 86) != "A" || letterGrade(scale, 70) != "B" || letterGrade(scale, 12.5) != "F" {
+		t.Errorf("unexpected letters for scale %+v", scale)
+	}
+
+	if _, err := parseGradeScale("A=90"); err != ErrInvalidGradeScale {
+		t.Errorf("expected ErrInvalidGradeScale, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// IdempotencyStore is a database backed store of idempotent responses, it is
+// shared by every instance of the server
+type IdempotencyStore struct{}
+
+// Reserve insert record when its key is free or expired, otherwise it returns the record holding the key
+func (IdempotencyStore) Reserve(record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	var existing IdempotencyRecord
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("key = ? AND expires_at < ?", record.Key, time.Now()).Delete(&IdempotencyRecord{})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		// the primary key decide between concurrent requests with the same key
+		res = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			return nil
+		}
+
+		return tx.Where("key = ?", record.Key).First(&existing).Error
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	return existing, existing.Key != "", nil
+}
+
+// Complete save the response of a reserved record
+func (IdempotencyStore) Complete(record IdempotencyRecord) error {
+	return DB.Save(&record).Error
+}
+
+// Release free the key of a record, e.g. after a server error the request can be retried
+func (IdempotencyStore) Release(key string) error {
+	return DB.Where("key = ?", key).Delete(&IdempotencyRecord{}).Error
+}
+
+// Purge remove records expired before
+func (IdempotencyStore) Purge(before time.Time) error {
+	return DB.Where("expires_at < ?", before).Delete(&IdempotencyRecord{}).Error
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net/url"
+)
+
+// SourceLDAP is the auth source of users provisioned from LDAP
+const SourceLDAP = "ldap"
+
+// LDAPConfig is a struct to store LDAP backend configuration
+type LDAPConfig struct {
+	// URL of the server, ldap:// or ldaps://
+	URL      string
+	StartTLS bool
+	// BindDN and BindPassword of the account searching users, anonymous search when empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter with %s replaced by the escaped username, default (uid=%s)
+	UserFilter string
+	// attributes mapped to the user, default uid, cn and memberOf
+	UsernameAttribute string
+	NameAttribute     string
+	GroupAttribute    string
+}
+
+// ldapBackend bind as the user found by UserFilter to verify the password
+type ldapBackend struct {
+	config LDAPConfig
+}
+
+func newLDAPBackend(config LDAPConfig) (ldapBackend, error) {
+	if config.URL == "" || config.BaseDN == "" {
+		return ldapBackend{}, errors.New("ldap backend needs url and base dn")
+	}
+
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = "uid"
+	}
+	if config.NameAttribute == "" {
+		config.NameAttribute = "cn"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+
+	return ldapBackend{config: config}, nil
+}
+
+func (ldapBackend) Name() string {
+	return SourceLDAP
+}
+
+func (backend ldapBackend) Authenticate(username, password string) (Identity, error) {
+	// an empty password is an unauthenticated bind which always succeeds
+	if username == "" || password == "" {
+		return Identity{}, ErrInvalidPassword
+	}
+
+	conn, err := ldap.DialURL(backend.config.URL)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	if backend.config.StartTLS {
+		serverName := ""
+		if u, err := url.Parse(backend.config.URL); err == nil {
+			serverName = u.Hostname()
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: serverName}); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	if backend.config.BindDN != "" {
+		if err := conn.Bind(backend.config.BindDN, backend.config.BindPassword); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		backend.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(backend.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{backend.config.UsernameAttribute, backend.config.NameAttribute, backend.config.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(result.Entries) != 1 {
+		return Identity{}, ErrNotFound
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return Identity{}, ErrInvalidPassword
+	}
+
+	identity := Identity{
+		Username: entry.GetAttributeValue(backend.config.UsernameAttribute),
+		Name:     entry.GetAttributeValue(backend.config.NameAttribute),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+	for _, group := range entry.GetAttributeValues(backend.config.GroupAttribute) {
+		identity.Groups = append(identity.Groups, groupName(group))
+	}
+
+	return identity, nil
+}
+
+// groupName returns the value of the first RDN of a group DN, cn=teachers,ou=groups,dc=school
+// gives teachers. Values which are not a DN are returned as is
+func groupName(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return group
+	}
+
+	return dn.RDNs[0].Attributes[0].Value
+}
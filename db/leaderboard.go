@@ -0,0 +1,189 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// sources of a score event
+const (
+	ScoreSourcePush     = "push"
+	ScoreSourceBulk     = "bulk"
+	ScoreSourceOverride = "override"
+)
+
+// anonymousName replace the name of students who opted out of leaderboards
+const anonymousName = "Anonymous"
+
+// scoreRef is the score of a student on a lab
+type scoreRef struct {
+	userId int
+	labId  int
+}
+
+var scoreHook func(ScoreEvent)
+
+// SetScoreHook set the function called after every score change, e.g. to stream it,
+// it must not block. nil disable score events
+func SetScoreHook(hook func(ScoreEvent)) {
+	scoreHook = hook
+}
+
+// publishScores call the score hook with the saved score of each ref
+func publishScores(source string, refs ...scoreRef) {
+	if scoreHook == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, ref := range refs {
+		var score Score
+		err := DB.Preload("User").Preload("Lab").
+			Where("user_id = ? AND lab_id = ?", ref.userId, ref.labId).First(&score).Error
+		if err != nil {
+			continue
+		}
+
+		event := ScoreEvent{
+			UserID:     score.UserID,
+			Username:   score.User.Username,
+			Name:       score.User.Name,
+			ClassID:    score.User.ClassID,
+			CourseID:   score.Lab.CourseID,
+			LabID:      score.LabID,
+			Lab:        score.Lab.Name,
+			Score:      score.Score,
+			MaxScore:   score.Lab.MaxScore,
+			Percentage: scorePercentage(score.Score, score.Lab.MaxScore),
+			Source:     source,
+			At:         now,
+		}
+		if score.User.LeaderboardOptOut {
+			event.UserID, event.Username, event.Name = 0, "", anonymousName
+		}
+
+		scoreHook(event)
+	}
+}
+
+// scorePercentage returns score out of maxScore as a percentage with two decimals
+func scorePercentage(score float64, maxScore float64) float64 {
+	if maxScore <= 0 {
+		return 0
+	}
+
+	return roundPercentage(score / maxScore * 100)
+}
+
+// GetLeaderboard is a function to rank the students of classId by their weighted percentage on the labs of
+// courseId, computed like the report card grade so labs of any max score count by their weight. Students with the
+// same percentage share a rank and students who opted out are anonymized
+func GetLeaderboard(classId int, courseId int) ([]LeaderboardEntry, error) {
+	if err := DB.Where("id = ?", classId).First(&Class{}).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if err := DB.Where("id = ?", courseId).First(&Course{}).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	var labs []Lab
+	if err := DB.Where("course_id = ?", courseId).Order("id").Find(&labs).Error; err != nil {
+		return nil, err
+	}
+	categories, err := GetGradeCategories(courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxTotal float64
+	for _, lab := range labs {
+		maxTotal += lab.MaxScore
+	}
+
+	courseLabs := DB.Model(&Lab{}).Select("id").Where("course_id = ?", courseId)
+
+	var entries []LeaderboardEntry
+	err = DB.Table("users").
+		Select("users.id AS user_id, users.username, users.name, users.leaderboard_opt_out AS opt_out, "+
+			"COALESCE(SUM(scores.score), 0) AS total, COUNT(scores.id) AS labs").
+		Joins("JOIN roles ON roles.id = users.role_id").
+		Joins("LEFT JOIN scores ON scores.user_id = users.id AND scores.lab_id IN (?)", courseLabs).
+		Where("users.class_id = ? AND roles.name = ?", classId, RoleStudent).
+		Group("users.id, users.username, users.name, users.leaderboard_opt_out").
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var scores []Score
+	err = DB.Select("scores.user_id, scores.lab_id, scores.score").
+		Joins("JOIN users ON users.id = scores.user_id").
+		Where("users.class_id = ? AND scores.lab_id IN (?)", classId, courseLabs).
+		Find(&scores).Error
+	if err != nil {
+		return nil, err
+	}
+	byUser := map[int]map[int]float64{}
+	for _, score := range scores {
+		if byUser[score.UserID] == nil {
+			byUser[score.UserID] = map[int]float64{}
+		}
+		byUser[score.UserID][score.LabID] = score.Score
+	}
+
+	// a lab without score counts as 0
+	for i := range entries {
+		gradedLabs := make([]GradedLab, len(labs))
+		for j, lab := range labs {
+			gradedLabs[j] = GradedLab{
+				Name:        lab.Name,
+				Category:    lab.Category,
+				Weight:      lab.Weight,
+				ExtraCredit: lab.ExtraCredit,
+				Score:       byUser[entries[i].UserID][lab.ID],
+				MaxScore:    lab.MaxScore,
+			}
+		}
+		_, entries[i].Percentage, _ = ComputeGrade(gradedLabs, categories, nil)
+	}
+
+	rankLeaderboard(entries, maxTotal)
+
+	return entries, nil
+}
+
+// rankLeaderboard sort entries by percentage then username, set their rank and
+// max total, and anonymize the students who opted out
+func rankLeaderboard(entries []LeaderboardEntry, maxTotal float64) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Percentage != entries[j].Percentage {
+			return entries[i].Percentage > entries[j].Percentage
+		}
+		return entries[i].Username < entries[j].Username
+	})
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if i > 0 && entries[i].Percentage == entries[i-1].Percentage {
+			entries[i].Rank = entries[i-1].Rank
+		}
+		entries[i].MaxTotal = maxTotal
+
+		if entries[i].OptOut {
+			entries[i].UserID, entries[i].Username, entries[i].Name = 0, "", anonymousName
+		}
+	}
+}
+
+// SetLeaderboardOptOut is a function to set if userId is anonymized on leaderboards
+func SetLeaderboardOptOut(userId int, optOut bool) error {
+	res := DB.Model(&User{}).Where("id = ?", userId).Update("leaderboard_opt_out", optOut)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
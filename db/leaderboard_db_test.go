@@ -0,0 +1,52 @@
+//go:build cgo
+
+package db
+
+import "testing"
+
+// TestGetLeaderboard verifies students are ranked by percentage and not by raw points,
+// so a lab with a small max score counts as much as the others
+func TestGetLeaderboard(t *testing.T) {
+	openTestDB(t)
+	class, course, lab := createTestLab(t, "XII TKJ 1", "Linux", "lab-1")
+	quiz := Lab{Name: "quiz-1", CourseID: course.ID, MaxScore: 10}
+	if err := DB.Create(&quiz).Error; err != nil {
+		t.Fatal(err)
+	}
+	points := createTestUser(t, "points", RoleStudent, class.ID)
+	steady := createTestUser(t, "steady", RoleStudent, class.ID)
+	createTestUser(t, "absent", RoleStudent, class.ID)
+
+	scores := []Score{
+		{UserID: points.ID, LabID: lab.ID, Score: 100},
+		{UserID: steady.ID, LabID: lab.ID, Score: 60},
+		{UserID: steady.ID, LabID: quiz.ID, Score: 10},
+	}
+	if err := DB.Create(&scores).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := GetLeaderboard(class.ID, course.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		username   string
+		total      float64
+		percentage float64
+	}{
+		{"steady", 70, 80},
+		{"points", 100, 50},
+		{"absent", 0, 0},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Rank != i+1 || entry.Username != expected[i].username || entry.Total != expected[i].total ||
+			entry.Percentage != expected[i].percentage || entry.MaxTotal != 110 {
+			t.Errorf("entry %d: unexpected %+v", i, entry)
+		}
+	}
+}
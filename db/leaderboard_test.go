@@ -0,0 +1,35 @@
+package db
+
+import "testing"
+
+// TestRankLeaderboard verifies entries are sorted by percentage, ties share a rank and students
+// who opted out are anonymized
+func TestRankLeaderboard(t *testing.T) {
+	entries := []LeaderboardEntry{
+		{UserID: 4, Username: "dave", Name: "Dave", Total: 180, Percentage: 10},
+		{UserID: 3, Username: "carol", Name: "Carol", Total: 150, Percentage: 75},
+		{UserID: 1, Username: "alice", Name: "Alice", Total: 20, Percentage: 90},
+		{UserID: 2, Username: "bob", Name: "Bob", Total: 150, Percentage: 75, OptOut: true},
+	}
+
+	rankLeaderboard(entries, 200)
+
+	expected := []struct {
+		rank int
+		name string
+	}{
+		{1, "Alice"},
+		{2, anonymousName},
+		{2, "Carol"},
+		{4, "Dave"},
+	}
+	for i, entry := range entries {
+		if entry.Rank != expected[i].rank || entry.Name != expected[i].name || entry.MaxTotal != 200 {
+			t.Errorf("entry %d: unexpected %+v", i, entry)
+		}
+	}
+
+	if entries[1].UserID != 0 || entries[1].Username != "" {
+		t.Errorf("opted out entry not anonymized: %+v", entries[1])
+	}
+}
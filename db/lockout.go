@@ -0,0 +1,31 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// AttemptStore is a database backed store of login attempts, it is shared by
+// every instance of the server
+type AttemptStore struct{}
+
+// Get returns the attempts of key, unknown key returns an empty attempt
+func (AttemptStore) Get(key string) (LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := DB.Where("key = ?", key).First(&attempt).Error
+	if err == gorm.ErrRecordNotFound {
+		return LoginAttempt{Key: key}, nil
+	}
+
+	return attempt, err
+}
+
+// Save insert or update the attempts of key
+func (AttemptStore) Save(attempt LoginAttempt) error {
+	return DB.Save(&attempt).Error
+}
+
+// Purge remove attempts not updated since before and no longer blocked
+func (AttemptStore) Purge(before time.Time) error {
+	return DB.Where("updated_at < ? AND blocked_until < ?", before, time.Now()).Delete(&LoginAttempt{}).Error
+}
@@ -4,22 +4,48 @@ import "time"
 
 // User represents a user of the system
 type User struct {
-	ID       int    `gorm:"primaryKey" json:"id"`
-	Username string `gorm:"uniqueIndex;not null" json:"username"`
-	Password string `gorm:"not null" json:"password"`
-	Name     string `gorm:"not null" json:"name"`
-	RoleID   int    `gorm:"not null" json:"role_id"`
-	Role     Role   `gorm:"foreignKey:RoleID"`
-	ClassID  int    `gorm:"not null" json:"class_id"`
-	Class    Class  `gorm:"foreignKey:ClassID"`
+	ID                    int        `gorm:"primaryKey" json:"id"`
+	Username              string     `gorm:"uniqueIndex;not null" json:"username"`
+	Password              string     `gorm:"not null" json:"password"`
+	PasswordScheme        string     `gorm:"not null;default:legacy" json:"-"`
+	Name                  string     `gorm:"not null" json:"name"`
+	RoleID                int        `gorm:"not null" json:"role_id"`
+	Role                  Role       `gorm:"foreignKey:RoleID"`
+	ClassID               int        `gorm:"not null" json:"class_id"`
+	Class                 Class      `gorm:"foreignKey:ClassID"`
+	TokenVersion          int        `gorm:"not null;default:0" json:"-"`
+	MustChangePassword    bool       `gorm:"not null;default:false" json:"must_change_password"`
+	TempPasswordExpiresAt *time.Time `json:"-"`
+	AuthSource            string     `gorm:"not null;default:local" json:"auth_source"`
+	TOTPSecret            string     `json:"-"`
+	TOTPEnabled           bool       `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter       int64      `gorm:"not null;default:0" json:"-"`
+	LeaderboardOptOut     bool       `gorm:"not null;default:false" json:"leaderboard_opt_out"`
 }
 
 // Role represents a role of the system
 type Role struct {
+	ID          int          `gorm:"primaryKey" json:"id"`
+	Name        string       `gorm:"uniqueIndex;not null" json:"name"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+}
+
+// Permission represents a named action granted to roles
+type Permission struct {
 	ID   int    `gorm:"primaryKey" json:"id"`
 	Name string `gorm:"uniqueIndex;not null" json:"name"`
 }
 
+// ClassAssignment represents a staff user assigned to a class, CourseID 0 means every course of the class
+type ClassAssignment struct {
+	ID       int   `gorm:"primaryKey" json:"id"`
+	UserID   int   `gorm:"not null;uniqueIndex:idx_class_assignment" json:"user_id"`
+	User     User  `gorm:"foreignKey:UserID" json:"-"`
+	ClassID  int   `gorm:"not null;uniqueIndex:idx_class_assignment" json:"class_id"`
+	Class    Class `gorm:"foreignKey:ClassID" json:"-"`
+	CourseID int   `gorm:"not null;default:0;uniqueIndex:idx_class_assignment" json:"course_id"`
+}
+
 // Class represents a class of the student
 type Class struct {
 	ID   int    `gorm:"primaryKey" json:"id"`
@@ -28,28 +54,202 @@ type Class struct {
 
 // Course represents a course
 type Course struct {
-	ID   int    `gorm:"primaryKey" json:"id"`
-	Name string `gorm:"uniqueIndex;not null" json:"name"`
+	ID               int    `gorm:"primaryKey" json:"id"`
+	Name             string `gorm:"uniqueIndex;not null" json:"name"`
+	ScoringPolicy    string `gorm:"not null;default:''" json:"scoring_policy"`
+	PolicyN          int    `gorm:"not null;default:0" json:"policy_n"`
+	RequireSignature bool   `gorm:"not null;default:false" json:"require_signature"`
+	SigningSecret    string `gorm:"not null;default:''" json:"-"`
+	GradeScale       string `gorm:"not null;default:''" json:"grade_scale"`
+}
+
+// GradeCategory represents a weighted group of labs in the grading scheme of a course
+type GradeCategory struct {
+	ID         int     `gorm:"primaryKey" json:"id"`
+	CourseID   int     `gorm:"not null;uniqueIndex:idx_grade_category" json:"course_id"`
+	Course     Course  `gorm:"foreignKey:CourseID" json:"-"`
+	Name       string  `gorm:"not null;uniqueIndex:idx_grade_category" json:"name"`
+	Weight     float64 `gorm:"not null" json:"weight"`
+	DropLowest int     `gorm:"not null;default:0" json:"drop_lowest"`
 }
 
 // Lab represents a lab of a course
 type Lab struct {
-	ID       int    `gorm:"primaryKey" json:"id"`
-	Name     string `gorm:"uniqueIndex;not null" json:"name"`
-	CourseID int    `gorm:"not null" json:"course_id"`
-	Course   Course `gorm:"foreignKey:CourseID"`
+	ID                int        `gorm:"primaryKey" json:"id"`
+	Name              string     `gorm:"uniqueIndex;not null" json:"name"`
+	CourseID          int        `gorm:"not null" json:"course_id"`
+	Course            Course     `gorm:"foreignKey:CourseID"`
+	ScoringPolicy     string     `gorm:"not null;default:''" json:"scoring_policy"`
+	PolicyN           int        `gorm:"not null;default:0" json:"policy_n"`
+	OpensAt           *time.Time `json:"opens_at"`
+	DueAt             *time.Time `json:"due_at"`
+	ClosesAt          *time.Time `json:"closes_at"`
+	LatePenaltyPerDay int        `gorm:"not null;default:0" json:"late_penalty_per_day"`
+	LatePenaltyCap    int        `gorm:"not null;default:0" json:"late_penalty_cap"`
+	LateGraceMinutes  int        `gorm:"not null;default:0" json:"late_grace_minutes"`
+	SigningSecret     string     `gorm:"not null;default:''" json:"-"`
+	MaxScore          float64    `gorm:"not null;default:100" json:"max_score"`
+	AllowFraction     bool       `gorm:"not null;default:false" json:"allow_fraction"`
+	Category          string     `gorm:"not null;default:''" json:"category"`
+	Weight            float64    `gorm:"not null;default:0" json:"weight"`
+	ExtraCredit       bool       `gorm:"not null;default:false" json:"extra_credit"`
+}
+
+// LabSchedule represents the availability window of a lab for a single class, replacing the one of the lab
+type LabSchedule struct {
+	ID       int        `gorm:"primaryKey" json:"id"`
+	LabID    int        `gorm:"not null;uniqueIndex:idx_lab_schedule" json:"lab_id"`
+	Lab      Lab        `gorm:"foreignKey:LabID" json:"-"`
+	ClassID  int        `gorm:"not null;uniqueIndex:idx_lab_schedule" json:"class_id"`
+	Class    Class      `gorm:"foreignKey:ClassID" json:"-"`
+	OpensAt  *time.Time `json:"opens_at"`
+	DueAt    *time.Time `json:"due_at"`
+	ClosesAt *time.Time `json:"closes_at"`
 }
 
 // Score represents a student score of a lab
 type Score struct {
+	ID             int       `gorm:"primaryKey" json:"id"`
+	UserID         int       `gorm:"not null;uniqueIndex:idx_score_user_lab" json:"user_id"`
+	User           User      `gorm:"foreignKey:UserID"`
+	LabID          int       `gorm:"not null;uniqueIndex:idx_score_user_lab" json:"lab_id"`
+	Lab            Lab       `gorm:"foreignKey:LabID"`
+	Score          float64   `gorm:"not null" json:"score"`
+	Penalty        float64   `gorm:"not null;default:0" json:"penalty"`
+	AttemptID      *int      `json:"attempt_id"`
+	OverrideAction string    `gorm:"not null;default:''" json:"override_action,omitempty"`
+	OverrideValue  float64   `gorm:"not null;default:0" json:"override_value,omitempty"`
+	OverrideReason string    `gorm:"not null;default:''" json:"override_reason,omitempty"`
+	OverrideLocked bool      `gorm:"not null;default:false" json:"override_locked,omitempty"`
+	CreatedAt      time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// Attempt represents a single score push of a student on a lab, the Score row is derived from attempts
+type Attempt struct {
+	ID            int       `gorm:"primaryKey" json:"id"`
+	UserID        int       `gorm:"not null;index:idx_attempt_user_lab" json:"user_id"`
+	User          User      `gorm:"foreignKey:UserID" json:"-"`
+	LabID         int       `gorm:"not null;index:idx_attempt_user_lab" json:"lab_id"`
+	Lab           Lab       `gorm:"foreignKey:LabID" json:"-"`
+	Score         float64   `gorm:"not null" json:"score"`
+	Penalty       float64   `gorm:"not null;default:0" json:"penalty"`
+	IP            string    `json:"ip"`
+	ClientVersion string    `json:"client_version"`
+	Details       string    `json:"details,omitempty"`
+	APIKeyID      *int      `json:"api_key_id,omitempty"`
+	CreatedAt     time.Time `gorm:"not null;index" json:"created_at"`
+}
+
+// AttemptCheck represents a named check of an attempt, the points of the checks sum to the attempt score
+type AttemptCheck struct {
+	ID        int     `gorm:"primaryKey" json:"-"`
+	AttemptID int     `gorm:"not null;index" json:"-"`
+	Attempt   Attempt `gorm:"foreignKey:AttemptID" json:"-"`
+	Name      string  `gorm:"not null" json:"name"`
+	Passed    bool    `gorm:"not null" json:"passed"`
+	Points    float64 `gorm:"not null" json:"points"`
+	MaxPoints float64 `gorm:"not null" json:"max_points"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// Artifact represents a file uploaded with an attempt, e.g. the grader log, the content is kept in blob storage
+type Artifact struct {
+	ID          int       `gorm:"primaryKey" json:"id"`
+	AttemptID   int       `gorm:"not null;uniqueIndex" json:"attempt_id"`
+	Attempt     Attempt   `gorm:"foreignKey:AttemptID" json:"-"`
+	Key         string    `gorm:"uniqueIndex;not null" json:"-"`
+	Filename    string    `gorm:"not null" json:"filename"`
+	ContentType string    `gorm:"not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	SHA256      string    `gorm:"not null" json:"sha256"`
+	ExpiresAt   time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
+}
+
+// ScoreNonce represents a nonce of a signed score submission, kept to refuse replays
+type ScoreNonce struct {
+	Nonce     string    `gorm:"primaryKey" json:"nonce"`
+	CreatedAt time.Time `gorm:"not null;index" json:"created_at"`
+}
+
+// ScoreOverride represents a manual change of a score, kept as audit trail
+type ScoreOverride struct {
+	ID            int       `gorm:"primaryKey" json:"id"`
+	UserID        int       `gorm:"not null;index:idx_override_user_lab" json:"user_id"`
+	User          User      `gorm:"foreignKey:UserID" json:"-"`
+	LabID         int       `gorm:"not null;index:idx_override_user_lab" json:"lab_id"`
+	Lab           Lab       `gorm:"foreignKey:LabID" json:"-"`
+	ActorID       *int      `json:"actor_id"`
+	Action        string    `gorm:"not null" json:"action"`
+	Value         float64   `gorm:"not null" json:"value"`
+	Locked        bool      `gorm:"not null" json:"locked"`
+	Reason        string    `gorm:"not null" json:"reason"`
+	PreviousScore float64   `gorm:"not null" json:"previous_score"`
+	NewScore      float64   `gorm:"not null" json:"new_score"`
+	CreatedAt     time.Time `gorm:"not null" json:"created_at"`
+}
+
+// RefreshToken represents a persisted refresh token, only the hash is stored
+type RefreshToken struct {
+	ID        int        `gorm:"primaryKey" json:"id"`
+	UserID    int        `gorm:"not null;index" json:"user_id"`
+	User      User       `gorm:"foreignKey:UserID" json:"-"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	MFA       bool       `gorm:"not null;default:false" json:"mfa"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// RecoveryCode represents a single use code replacing a TOTP code, only the hash is stored
+type RecoveryCode struct {
+	ID       int        `gorm:"primaryKey" json:"id"`
+	UserID   int        `gorm:"not null;index" json:"user_id"`
+	User     User       `gorm:"foreignKey:UserID" json:"-"`
+	CodeHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// RevokedToken represents a single revoked access token
+type RevokedToken struct {
 	ID        int       `gorm:"primaryKey" json:"id"`
-	UserID    int       `gorm:"not null" json:"user_id"`
-	User      User      `gorm:"foreignKey:UserID"`
-	LabID     int       `gorm:"not null" json:"lab_id"`
-	Lab       Lab       `gorm:"foreignKey:LabID"`
-	Score     int       `gorm:"not null" json:"score"`
+	UserID    int       `gorm:"not null;index" json:"user_id"`
+	TokenID   string    `gorm:"uniqueIndex;not null" json:"token_id"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
 	CreatedAt time.Time `gorm:"not null" json:"created_at"`
-	UpdatedAt time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// LoginAttempt represents failed login attempts of a rate limit key, e.g. client ip
+type LoginAttempt struct {
+	Key          string    `gorm:"primaryKey" json:"key"`
+	Failures     int       `gorm:"not null" json:"failures"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	UpdatedAt    time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// IdempotencyRecord represents the stored response of a request sent with an Idempotency-Key
+type IdempotencyRecord struct {
+	Key         string    `gorm:"primaryKey" json:"key"`
+	RequestHash string    `gorm:"not null" json:"-"`
+	Status      int       `gorm:"not null" json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"-"`
+	ExpiresAt   time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// APIKey represents a long-lived key of a service account, only the hash is stored
+type APIKey struct {
+	ID         int        `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;not null" json:"prefix"`
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"-"`
+	RoleID     int        `gorm:"not null" json:"role_id"`
+	Role       Role       `gorm:"foreignKey:RoleID" json:"-"`
+	Courses    []Course   `gorm:"many2many:api_key_courses" json:"courses"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
 }
 
 // Student response struct
@@ -57,6 +257,8 @@ type Student struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Name     string `json:"name"`
+	// LockedUntil is filled from the login attempt store, not from the users table
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
 }
 
 // GeneralData struct for data
@@ -65,25 +267,122 @@ type GeneralData struct {
 	Name string `json:"name"`
 }
 
+// LabInfo is a lab with its effective scoring policy
+type LabInfo struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	ScoringPolicy string     `json:"scoring_policy"`
+	PolicyN       int        `json:"policy_n,omitempty"`
+	OpensAt       *time.Time `json:"opens_at,omitempty"`
+	DueAt         *time.Time `json:"due_at,omitempty"`
+	ClosesAt      *time.Time `json:"closes_at,omitempty"`
+	MaxScore      float64    `json:"max_score"`
+	AllowFraction bool       `json:"allow_fraction,omitempty"`
+	Category      string     `json:"category,omitempty"`
+	Weight        float64    `json:"weight"`
+	ExtraCredit   bool       `json:"extra_credit,omitempty"`
+}
+
+// CheckSummary is how a class did on a check of a lab
+type CheckSummary struct {
+	Lab           string  `json:"lab"`
+	Name          string  `json:"name"`
+	Passed        int     `json:"passed"`
+	Total         int     `json:"total"`
+	AveragePoints float64 `json:"average_points"`
+	MaxPoints     float64 `json:"max_points"`
+}
+
 // Report struct
 type Report struct {
-	Name     string      `json:"name"`
-	Username string      `json:"username"`
-	Scores   []ScoreLabs `json:"scores,omitempty"`
-	Average  float64     `json:"average"`
-	Total    int         `json:"total"`
+	Name       string          `json:"name"`
+	Username   string          `json:"username"`
+	Scores     []ScoreLabs     `json:"scores,omitempty"`
+	Average    float64         `json:"average"`
+	Total      float64         `json:"total"`
+	Categories []CategoryGrade `json:"categories,omitempty"`
+	Percentage float64         `json:"percentage"`
+	Grade      string          `json:"grade"`
 }
 
 // ScoreLab single report score based on lab name
 type ScoreLab struct {
-	LabName string `json:"lab" gorm:"column:name"`
-	Score   int    `json:"score"`
+	UserID         int            `json:"-"`
+	LabID          int            `json:"-"`
+	LabName        string         `json:"lab" gorm:"column:name"`
+	Score          float64        `json:"score"`
+	Penalty        float64        `json:"penalty,omitempty"`
+	OverrideAction string         `json:"override_action,omitempty"`
+	OverrideReason string         `json:"override_reason,omitempty"`
+	Checks         []AttemptCheck `json:"checks,omitempty" gorm:"-"`
 }
 
 type ScoreLabs struct {
-	LabName string `json:"lab_name"`
-	Score   int    `json:"score"`
-	ID      int    `json:"id"`
+	LabName        string  `json:"lab_name"`
+	Score          float64 `json:"score"`
+	Penalty        float64 `json:"penalty"`
+	OverrideAction string  `json:"override_action,omitempty"`
+	OverrideReason string  `json:"override_reason,omitempty"`
+	MaxScore       float64 `json:"max_score"`
+	Percentage     float64 `json:"percentage"`
+	ID             int     `json:"id"`
+}
+
+// statuses of a lab in the progress of a student
+const (
+	ProgressNotStarted = "not_started"
+	ProgressInProgress = "in_progress"
+	ProgressPassed     = "passed"
+)
+
+// LabProgress is the progress of a student on a lab with its deadlines
+type LabProgress struct {
+	ID         int        `json:"id"`
+	Lab        string     `json:"lab"`
+	Category   string     `json:"category,omitempty"`
+	Score      float64    `json:"score"`
+	MaxScore   float64    `json:"max_score"`
+	Percentage float64    `json:"percentage"`
+	Penalty    float64    `json:"penalty"`
+	Attempts   int        `json:"attempts"`
+	Status     string     `json:"status"`
+	OpensAt    *time.Time `json:"opens_at,omitempty"`
+	DueAt      *time.Time `json:"due_at,omitempty"`
+	ClosesAt   *time.Time `json:"closes_at,omitempty"`
+}
+
+// ScoreEvent is a change of the score of a student on a lab, published once the change is saved
+type ScoreEvent struct {
+	UserID     int       `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	Name       string    `json:"name"`
+	ClassID    int       `json:"class_id"`
+	CourseID   int       `json:"course_id"`
+	LabID      int       `json:"lab_id"`
+	Lab        string    `json:"lab"`
+	Score      float64   `json:"score"`
+	MaxScore   float64   `json:"max_score"`
+	Percentage float64   `json:"percentage"`
+	Source     string    `json:"source"`
+	At         time.Time `json:"at"`
+}
+
+// LeaderboardEntry is the rank of a student of a class on the labs of a course
+type LeaderboardEntry struct {
+	Rank       int     `json:"rank"`
+	UserID     int     `json:"user_id,omitempty"`
+	Username   string  `json:"username,omitempty"`
+	Name       string  `json:"name"`
+	Total      float64 `json:"total"`
+	MaxTotal   float64 `json:"max_total"`
+	Percentage float64 `json:"percentage"`
+	Labs       int     `json:"labs"`
+	OptOut     bool    `json:"-"`
+}
+
+// LeaderboardPreference is the choice of a student to be anonymized on leaderboards
+type LeaderboardPreference struct {
+	OptOut bool `json:"opt_out"`
 }
 
 // Login Model
@@ -92,9 +391,73 @@ type Login struct {
 	Password string `json:"password"`
 }
 
+// RefreshRequest Model
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PasswordChange Model
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// APIKeyRequest Model
+type APIKeyRequest struct {
+	Name      string `json:"name"`
+	CourseIDs []int  `json:"course_ids"`
+}
+
+// MFACode Model
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+// MFALogin Model
+type MFALogin struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
 // ScorePush struct
 type ScorePush struct {
-	Username string `json:"username"`
-	Lab      string `json:"lab"`
-	Score    int    `json:"score"`
+	Username      string  `json:"username"`
+	Lab           string  `json:"lab"`
+	Score         float64 `json:"score"`
+	ClientVersion string  `json:"client_version"`
+	Details       string  `json:"details"`
+	// Checks is the optional breakdown of Score
+	Checks []AttemptCheck `json:"checks"`
+	// Nonce, Timestamp and Signature sign the submission with the lab or course secret
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
+	// Artifact is the file uploaded with the push, already stored by the handler
+	Artifact *Artifact `json:"-"`
+	// IP is set from the request, not from the payload
+	IP string `json:"-"`
+}
+
+// OverrideRequest is a manual change of the score of a student on a lab
+type OverrideRequest struct {
+	UserID int     `json:"user_id"`
+	LabID  int     `json:"lab_id"`
+	Action string  `json:"action"`
+	Value  float64 `json:"value"`
+	Reason string  `json:"reason"`
+	// Lock keep the override when a new attempt is pushed, the configured default when nil
+	Lock *bool `json:"lock"`
+}
+
+// AttemptLog single attempt with its lab name
+type AttemptLog struct {
+	ID            int       `json:"id"`
+	Lab           string    `json:"lab"`
+	Score         float64   `json:"score"`
+	Penalty       float64   `json:"penalty"`
+	IP            string    `json:"ip"`
+	ClientVersion string    `json:"client_version"`
+	Details       string    `json:"details,omitempty"`
+	Artifact      bool      `json:"artifact"`
+	CreatedAt     time.Time `json:"created_at"`
 }
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+// override actions of OverrideRequest
+const (
+	// OverrideSet replace the score by Value
+	OverrideSet = "set"
+	// OverrideAdjust add Value to the score derived from attempts
+	OverrideAdjust = "adjust"
+	// OverrideClear remove the override, the score is derived from attempts again
+	OverrideClear = "clear"
+)
+
+var (
+	ErrReasonRequired  = errors.New("reason required")
+	ErrInvalidOverride = errors.New("invalid override")
+	ErrNotOverridden   = errors.New("score not overridden")
+)
+
+// applyOverride returns the score shown for scores when effective is derived from
+// attempts, adjusted scores are kept between 0 and maxScore
+func applyOverride(scores Score, effective float64, maxScore float64) float64 {
+	switch scores.OverrideAction {
+	case OverrideSet:
+		return scores.OverrideValue
+	case OverrideAdjust:
+		adjusted := effective + scores.OverrideValue
+		if adjusted < 0 {
+			return 0
+		}
+		if adjusted > maxScore {
+			return maxScore
+		}
+		return adjusted
+	default:
+		return effective
+	}
+}
+
+// validateOverride check action, value and reason of request on a lab of maxScore
+func validateOverride(request OverrideRequest, maxScore float64) error {
+	if request.UserID == 0 || request.LabID == 0 {
+		return ErrCantBeEmpty
+	}
+	if strings.TrimSpace(request.Reason) == "" {
+		return ErrReasonRequired
+	}
+
+	switch request.Action {
+	case OverrideSet:
+		if request.Value < 0 || request.Value > maxScore {
+			return ErrInvalidOverride
+		}
+	case OverrideAdjust:
+		if request.Value < -maxScore || request.Value > maxScore || request.Value == 0 {
+			return ErrInvalidOverride
+		}
+	case OverrideClear:
+	default:
+		return ErrInvalidOverride
+	}
+
+	return nil
+}
+
+// OverrideScore is a function to set, adjust or clear manually the score of a
+// student on a lab, every change is kept in the audit trail with its reason
+func OverrideScore(actorId int, request OverrideRequest) (override ScoreOverride, err error) {
+	var lab Lab
+	if request.LabID != 0 {
+		if err := DB.Where("id = ?", request.LabID).First(&lab).Error; err != nil {
+			return override, ErrNotFound
+		}
+	}
+	if err := validateOverride(request, lab.MaxScore); err != nil {
+		return override, err
+	}
+
+	locked := !scoringConfig.UnlockOverrides
+	if request.Lock != nil {
+		locked = *request.Lock
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", request.UserID).First(&User{}).Error; err != nil {
+			return ErrNotFound
+		}
+
+		var scores Score
+		found := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND lab_id = ?", request.UserID, request.LabID).
+			Limit(1).Find(&scores).RowsAffected > 0
+
+		if request.Action == OverrideClear {
+			if !found || scores.OverrideAction == "" {
+				return ErrNotOverridden
+			}
+			scores.OverrideAction, scores.OverrideValue, scores.OverrideReason, scores.OverrideLocked = "", 0, "", false
+		} else {
+			scores.UserID, scores.LabID = request.UserID, request.LabID
+			scores.OverrideAction = request.Action
+			scores.OverrideValue = request.Value
+			scores.OverrideReason = request.Reason
+			scores.OverrideLocked = locked
+		}
+
+		override = ScoreOverride{
+			UserID:        request.UserID,
+			LabID:         request.LabID,
+			ActorID:       &actorId,
+			Action:        request.Action,
+			Value:         request.Value,
+			Locked:        locked && request.Action != OverrideClear,
+			Reason:        request.Reason,
+			PreviousScore: scores.Score,
+		}
+
+		// a set score can exist without any attempt
+		if err := tx.Save(&scores).Error; err != nil {
+			return err
+		}
+		if _, err := recomputeScore(tx, request.UserID, request.LabID); err != nil {
+			return err
+		}
+
+		var updated Score
+		tx.Where("user_id = ? AND lab_id = ?", request.UserID, request.LabID).Limit(1).Find(&updated)
+		override.NewScore = updated.Score
+
+		return tx.Create(&override).Error
+	})
+	if err != nil {
+		return ScoreOverride{}, err
+	}
+
+	if override.NewScore != override.PreviousScore {
+		publishScores(ScoreSourceOverride, scoreRef{request.UserID, request.LabID})
+	}
+
+	return override, nil
+}
+
+// releaseOverride clear an unlocked override of scores before a new attempt is derived
+func releaseOverride(tx *gorm.DB, scores Score) error {
+	if scores.OverrideAction == "" || scores.OverrideLocked {
+		return nil
+	}
+
+	res := tx.Model(&Score{}).Where("id = ?", scores.ID).Updates(map[string]interface{}{
+		"override_action": "",
+		"override_value":  0,
+		"override_reason": "",
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return tx.Create(&ScoreOverride{
+		UserID:        scores.UserID,
+		LabID:         scores.LabID,
+		Action:        OverrideClear,
+		Reason:        "replaced by a new attempt",
+		PreviousScore: scores.Score,
+		NewScore:      scores.Score,
+	}).Error
+}
+
+// GetScoreOverrides is a function to get the audit trail of overrides of userId from
+// newest to oldest, filtered by labId when not 0
+func GetScoreOverrides(userId int, labId int) ([]ScoreOverride, error) {
+	var overrides []ScoreOverride
+
+	query := DB.Where("user_id = ?", userId).Order("created_at DESC, id DESC")
+	if labId != 0 {
+		query = query.Where("lab_id = ?", labId)
+	}
+
+	if err := query.Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
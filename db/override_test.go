@@ -0,0 +1,49 @@
+package db
+
+import "testing"
+
+// TestApplyOverride verifies set replace the derived score and adjust is kept between 0 and 100
+func TestApplyOverride(t *testing.T) {
+	tests := []struct {
+		scores   Score
+		expected float64
+	}{
+		{Score{}, 70},
+		{Score{OverrideAction: OverrideSet, OverrideValue: 85}, 85},
+		{Score{OverrideAction: OverrideAdjust, OverrideValue: 10}, 80},
+		{Score{OverrideAction: OverrideAdjust, OverrideValue: 50}, 100},
+		{Score{OverrideAction: OverrideAdjust, OverrideValue: -90}, 0},
+	}
+
+	for _, test := range tests {
+		if score := applyOverride(test.scores, 70, 100); score != test.expected {
+			t.Errorf("%s %v: expected %v, got %v", test.scores.OverrideAction, test.scores.OverrideValue, test.expected, score)
+		}
+	}
+}
+
+// TestValidateOverride verifies the reason is required and values are in range
+func TestValidateOverride(t *testing.T) {
+	request := OverrideRequest{UserID: 1, LabID: 1, Action: OverrideSet, Value: 85, Reason: "checker bug"}
+	if err := validateOverride(request, 100); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	noReason := request
+	noReason.Reason = " "
+	if err := validateOverride(noReason, 100); err != ErrReasonRequired {
+		t.Errorf("expected ErrReasonRequired, got %v", err)
+	}
+
+	tooHigh := request
+	tooHigh.Value = 120
+	if err := validateOverride(tooHigh, 100); err != ErrInvalidOverride {
+		t.Errorf("expected ErrInvalidOverride, got %v", err)
+	}
+
+	unknown := request
+	unknown.Action = "raise"
+	if err := validateOverride(unknown, 100); err != ErrInvalidOverride {
+		t.Errorf("expected ErrInvalidOverride, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+const (
+	// SchemeLegacy is bcrypt over password + static salt, kept only to verify old hashes
+	SchemeLegacy   = "legacy"
+	SchemeBcrypt   = "bcrypt"
+	SchemeArgon2id = "argon2id"
+
+	// legacySalt was appended to every password before per-user salts
+	legacySalt = "shopiea"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("unsupported password scheme")
+	ErrInvalidHash       = errors.New("invalid password hash")
+)
+
+// PasswordConfig is a struct to store password hashing configuration
+type PasswordConfig struct {
+	// Algorithm used for new hashes, bcrypt or argon2id
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory in KiB
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+	// TempPasswordTTL is how long a password from an admin reset is valid
+	TempPasswordTTL time.Duration
+}
+
+// passwordConfig is the active configuration, defaults follow the argon2 RFC recommendation
+var passwordConfig = PasswordConfig{
+	Algorithm:       SchemeBcrypt,
+	BcryptCost:      bcrypt.DefaultCost,
+	Argon2Memory:    64 * 1024,
+	Argon2Time:      1,
+	Argon2Threads:   4,
+	TempPasswordTTL: 24 * time.Hour,
+}
+
+// InitPasswordHasher set the hashing configuration, zero values keep the default
+func (config PasswordConfig) InitPasswordHasher() error {
+	if config.Algorithm == "" {
+		config.Algorithm = passwordConfig.Algorithm
+	}
+	if config.Algorithm != SchemeBcrypt && config.Algorithm != SchemeArgon2id {
+		return fmt.Errorf("%w: %s", ErrUnsupportedScheme, config.Algorithm)
+	}
+
+	if config.BcryptCost == 0 {
+		config.BcryptCost = passwordConfig.BcryptCost
+	}
+	if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if config.Argon2Memory == 0 {
+		config.Argon2Memory = passwordConfig.Argon2Memory
+	}
+	if config.Argon2Time == 0 {
+		config.Argon2Time = passwordConfig.Argon2Time
+	}
+	if config.Argon2Threads == 0 {
+		config.Argon2Threads = passwordConfig.Argon2Threads
+	}
+	if config.TempPasswordTTL == 0 {
+		config.TempPasswordTTL = passwordConfig.TempPasswordTTL
+	}
+
+	passwordConfig = config
+	return nil
+}
+
+// hashPassword hash a plain text password with the configured algorithm and
+// returns the hash with the scheme to store alongside it
+func hashPassword(password string) (hash string, scheme string, err error) {
+	switch passwordConfig.Algorithm {
+	case SchemeArgon2id:
+		hash, err = hashArgon2id(password)
+		return hash, SchemeArgon2id, err
+	default:
+		raw, err := bcrypt.GenerateFromPassword([]byte(password), passwordConfig.BcryptCost)
+		if err != nil {
+			return "", "", err
+		}
+		return string(raw), SchemeBcrypt, nil
+	}
+}
+
+// verifyPassword takes a plain text password and a stored hash with its scheme, and returns whether they match
+func verifyPassword(plainPassword, hashedPassword, scheme string) bool {
+	switch scheme {
+	case SchemeLegacy:
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword+legacySalt))
+		return err == nil
+	case SchemeBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
+		return err == nil
+	case SchemeArgon2id:
+		ok, err := verifyArgon2id(plainPassword, hashedPassword)
+		return err == nil && ok
+	default:
+		return false
+	}
+}
+
+// needsRehash report whether a stored hash is not using the configured algorithm or parameters
+func needsRehash(hashedPassword, scheme string) bool {
+	if scheme != passwordConfig.Algorithm {
+		return true
+	}
+
+	switch scheme {
+	case SchemeBcrypt:
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		return err != nil || cost != passwordConfig.BcryptCost
+	case SchemeArgon2id:
+		params, _, _, err := decodeArgon2id(hashedPassword)
+		return err != nil || params != [3]uint32{passwordConfig.Argon2Memory, passwordConfig.Argon2Time, uint32(passwordConfig.Argon2Threads)}
+	default:
+		return true
+	}
+}
+
+// hashArgon2id returns the hash in PHC string format, $argon2id$v=19$m=65536,t=1,p=4$salt$key
+func hashArgon2id(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, passwordConfig.Argon2Time, passwordConfig.Argon2Memory, passwordConfig.Argon2Threads, 32)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		passwordConfig.Argon2Memory,
+		passwordConfig.Argon2Time,
+		passwordConfig.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyArgon2id recompute the key with the parameters stored in the hash
+func verifyArgon2id(password, hashedPassword string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hashedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params[1], params[0], uint8(params[2]), uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// decodeArgon2id parse a PHC string and returns memory, time, threads with salt and key
+func decodeArgon2id(hashedPassword string) (params [3]uint32, salt []byte, key []byte, err error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != SchemeArgon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params[0], &params[1], &params[2]); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if params[2] == 0 || params[2] > 255 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
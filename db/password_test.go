@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestPasswordSchemes verifies hash and verify round trip for every configurable algorithm
+func TestPasswordSchemes(t *testing.T) {
+	defer func(config PasswordConfig) { passwordConfig = config }(passwordConfig)
+
+	configs := []PasswordConfig{
+		{Algorithm: SchemeBcrypt, BcryptCost: bcrypt.MinCost},
+		{Algorithm: SchemeArgon2id, Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1},
+	}
+
+	for _, config := range configs {
+		if err := config.InitPasswordHasher(); err != nil {
+			t.Fatal(err)
+		}
+
+		hash, scheme, err := hashPassword("s3cret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scheme != config.Algorithm {
+			t.Errorf("expected scheme %s, got %s", config.Algorithm, scheme)
+		}
+		if !verifyPassword("s3cret", hash, scheme) {
+			t.Errorf("%s: valid password should match", scheme)
+		}
+		if verifyPassword("wrong", hash, scheme) {
+			t.Errorf("%s: invalid password should not match", scheme)
+		}
+		if needsRehash(hash, scheme) {
+			t.Errorf("%s: fresh hash should not need rehash", scheme)
+		}
+
+		// two hashes of the same password must use different salts
+		other, _, _ := hashPassword("s3cret")
+		if other == hash {
+			t.Errorf("%s: hashes should be salted per password", scheme)
+		}
+	}
+}
+
+// TestLegacyPasswordNeedsRehash verifies the static salt scheme is still accepted and flagged for migration
+func TestLegacyPasswordNeedsRehash(t *testing.T) {
+	defer func(config PasswordConfig) { passwordConfig = config }(passwordConfig)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("s3cret"+legacySalt), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !verifyPassword("s3cret", string(legacy), SchemeLegacy) {
+		t.Error("legacy password should match")
+	}
+	if verifyPassword("s3cret", string(legacy), SchemeBcrypt) {
+		t.Error("legacy hash should not match without the static salt")
+	}
+	if !needsRehash(string(legacy), SchemeLegacy) {
+		t.Error("legacy hash should need rehash")
+	}
+
+	// changing the cost flag existing bcrypt hashes too
+	config := PasswordConfig{Algorithm: SchemeBcrypt, BcryptCost: bcrypt.MinCost + 1}
+	if err := config.InitPasswordHasher(); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if !needsRehash(string(hash), SchemeBcrypt) {
+		t.Error("bcrypt hash with outdated cost should need rehash")
+	}
+}
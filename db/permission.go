@@ -0,0 +1,280 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// role names created by the migration
+const (
+	RoleAdmin      = "admin"
+	RoleStudent    = "student"
+	RoleInstructor = "instructor"
+	RoleAssistant  = "assistant"
+	RoleService    = "service"
+)
+
+// permission names checked by handlers.RequirePermission
+const (
+	PermUsersRead      = "users:read"
+	PermUsersWrite     = "users:write"
+	PermClassesRead    = "classes:read"
+	PermClassesWrite   = "classes:write"
+	PermCoursesWrite   = "courses:write"
+	PermLabsWrite      = "labs:write"
+	PermScoresExport   = "scores:export"
+	PermRolesManage    = "roles:manage"
+	PermScoresPush     = "scores:push"
+	PermScoresOverride = "scores:override"
+)
+
+// defaultRoles is the permission set of each role created by the migration,
+// admin and student are created first to keep their historical ids 1 and 2
+var defaultRoles = []struct {
+	name        string
+	permissions []string
+}{
+	{RoleAdmin, []string{
+		PermUsersRead, PermUsersWrite, PermClassesRead, PermClassesWrite,
+		PermCoursesWrite, PermLabsWrite, PermScoresExport, PermRolesManage, PermScoresOverride,
+	}},
+	{RoleStudent, nil},
+	{RoleInstructor, []string{
+		PermUsersRead, PermUsersWrite, PermClassesRead, PermLabsWrite, PermScoresExport, PermScoresOverride,
+	}},
+	{RoleAssistant, []string{PermUsersRead, PermClassesRead, PermScoresExport}},
+	{RoleService, []string{PermScoresPush}},
+}
+
+// Scope is what a permission is checked against, zero fields are not checked
+type Scope struct {
+	ClassID  int
+	CourseID int
+	// UserID is the user the request acts on, its class is used when ClassID is zero
+	UserID int
+}
+
+// seedRoles create missing permissions and roles, an existing role receive the
+// default permissions it is missing, e.g. added by a later version
+func seedRoles() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, defaultRole := range defaultRoles {
+			var role Role
+			if err := tx.Preload("Permissions").Where(Role{Name: defaultRole.name}).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+
+			granted := map[string]bool{}
+			for _, permission := range role.Permissions {
+				granted[permission.Name] = true
+			}
+
+			var permissions []Permission
+			for _, name := range defaultRole.permissions {
+				if granted[name] {
+					continue
+				}
+				var permission Permission
+				if err := tx.Where(Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+					return err
+				}
+				permissions = append(permissions, permission)
+			}
+			if len(permissions) == 0 {
+				continue
+			}
+
+			if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetUserRole is a function to get role of userId with its permissions
+func GetUserRole(userId int) (Role, error) {
+	var user User
+	if err := DB.Preload("Role.Permissions").Where("id = ?", userId).First(&user).Error; err != nil {
+		return Role{}, ErrNotFound
+	}
+
+	return user.Role, nil
+}
+
+// HasPermission check if userId is allowed to do permission on scope. Admin is
+// allowed everything, other roles need the permission and a class assignment
+// matching the scope. Users other than students can only be managed by admin
+func HasPermission(userId int, permission string, scope Scope) (bool, error) {
+	role, err := GetUserRole(userId)
+	if err != nil {
+		return false, err
+	}
+
+	if role.Name == RoleAdmin {
+		return true, nil
+	}
+
+	var granted bool
+	for _, p := range role.Permissions {
+		if p.Name == permission {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return false, nil
+	}
+
+	if scope.UserID != 0 {
+		var target User
+		if err := DB.Preload("Role").Where("id = ?", scope.UserID).First(&target).Error; err != nil {
+			return false, ErrNotFound
+		}
+		if target.Role.Name != RoleStudent {
+			return false, nil
+		}
+		if scope.ClassID == 0 {
+			scope.ClassID = target.ClassID
+		}
+	}
+
+	if scope.ClassID == 0 && scope.CourseID == 0 {
+		return true, nil
+	}
+
+	query := DB.Model(&ClassAssignment{}).Where("user_id = ?", userId)
+	if scope.ClassID != 0 {
+		query = query.Where("class_id = ?", scope.ClassID)
+		if scope.CourseID != 0 {
+			query = query.Where("course_id = 0 OR course_id = ?", scope.CourseID)
+		}
+	} else {
+		// without class only an explicit course assignment grants access to the course
+		query = query.Where("course_id = ?", scope.CourseID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetRoles is a function to get all roles with their permissions
+func GetRoles() ([]Role, error) {
+	var roles []Role
+	res := DB.Preload("Permissions").Find(&roles)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return roles, nil
+}
+
+// GetRoleIdByName is a function to get role id based on role name
+func GetRoleIdByName(name string) (int, error) {
+	var role Role
+	if err := DB.Where("name = ?", name).First(&role).Error; err != nil {
+		return 0, ErrNotFound
+	}
+
+	return role.ID, nil
+}
+
+// CreateAssignment is a function to assign a user to a class and optionally a course
+func CreateAssignment(assignment ClassAssignment) (result ClassAssignment, err error) {
+	if assignment.UserID == 0 || assignment.ClassID == 0 {
+		return result, ErrCantBeEmpty
+	}
+
+	var count int64
+	DB.Model(&ClassAssignment{}).
+		Where("user_id = ? AND class_id = ? AND course_id = ?", assignment.UserID, assignment.ClassID, assignment.CourseID).
+		Count(&count)
+	if count > 0 {
+		return result, ErrAlreadyExist
+	}
+
+	res := DB.Create(&assignment)
+	if res.Error != nil {
+		return result, res.Error
+	}
+
+	return assignment, nil
+}
+
+// GetAssignments is a function to get assignments, filtered by userId when not zero
+func GetAssignments(userId int) ([]ClassAssignment, error) {
+	var assignments []ClassAssignment
+	query := DB.Model(&ClassAssignment{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+
+	if err := query.Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// DeleteAssignmentById is a function to delete assignment based on assignmentId
+func DeleteAssignmentById(assignmentId int) error {
+	var assignment ClassAssignment
+	if err := DB.Where("id = ?", assignmentId).First(&assignment).Error; err != nil {
+		return ErrNotFound
+	}
+
+	res := DB.Delete(&assignment)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return nil
+}
+
+// GetClassesByUserId is a function to get classes visible for userId, every class for admin
+// and the assigned classes for other roles
+func GetClassesByUserId(userId string) ([]Class, error) {
+	if IsAdmin(userId) {
+		return GetClasses()
+	}
+
+	userIdInt, _ := strconv.Atoi(userId)
+
+	var classes []Class
+	res := DB.Where("id IN (?)", DB.Model(&ClassAssignment{}).Select("class_id").Where("user_id = ?", userIdInt)).
+		Find(&classes)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return classes, nil
+}
+
+// GetLabCourseId is a function to get course id of a lab based on labId
+func GetLabCourseId(labId int) (int, error) {
+	var lab Lab
+	if err := DB.Where("id = ?", labId).First(&lab).Error; err != nil {
+		return 0, ErrNotFound
+	}
+
+	return lab.CourseID, nil
+}
+
+// GetPermissionNames is a function to get permission names of userId role
+func GetPermissionNames(userId int) (role string, permissions []string, err error) {
+	userRole, err := GetUserRole(userId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, permission := range userRole.Permissions {
+		permissions = append(permissions, permission.Name)
+	}
+
+	return userRole.Name, permissions, nil
+}
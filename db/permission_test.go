@@ -0,0 +1,73 @@
+//go:build cgo
+
+package db
+
+import "testing"
+
+// TestHasPermission verifies admin is allowed everything, other roles need the permission
+// and an assignment matching the scope, and only students can be managed by them
+func TestHasPermission(t *testing.T) {
+	openTestDB(t)
+
+	admin := createTestUser(t, "admin", RoleAdmin, 1)
+	instructor := createTestUser(t, "instructor", RoleInstructor, 1)
+	assistant := createTestUser(t, "assistant", RoleAssistant, 1)
+	otherInstructor := createTestUser(t, "other", RoleInstructor, 1)
+	studentA := createTestUser(t, "student-a", RoleStudent, 1)
+	studentB := createTestUser(t, "student-b", RoleStudent, 2)
+
+	// instructor teaches every course of class 1 and course 3 of class 2, assistant only course 3 of class 1
+	assignments := []ClassAssignment{
+		{UserID: instructor.ID, ClassID: 1},
+		{UserID: instructor.ID, ClassID: 2, CourseID: 3},
+		{UserID: assistant.ID, ClassID: 1, CourseID: 3},
+	}
+	for _, assignment := range assignments {
+		if _, err := CreateAssignment(assignment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name       string
+		userId     int
+		permission string
+		scope      Scope
+		expected   bool
+	}{
+		{"admin without permission or assignment", admin.ID, PermRolesManage, Scope{ClassID: 9}, true},
+		{"admin manages staff", admin.ID, PermUsersWrite, Scope{UserID: otherInstructor.ID}, true},
+		{"missing permission", assistant.ID, PermUsersWrite, Scope{ClassID: 1}, false},
+		{"unscoped permission", instructor.ID, PermLabsWrite, Scope{}, true},
+		{"student has no permission", studentA.ID, PermUsersRead, Scope{}, false},
+		{"assigned class", instructor.ID, PermUsersRead, Scope{ClassID: 1}, true},
+		{"class assignment covers every course", instructor.ID, PermScoresExport, Scope{ClassID: 1, CourseID: 5}, true},
+		{"course assignment of the class", instructor.ID, PermScoresExport, Scope{ClassID: 2, CourseID: 3}, true},
+		{"other course of a course assignment", instructor.ID, PermScoresExport, Scope{ClassID: 2, CourseID: 4}, false},
+		{"unassigned class", assistant.ID, PermUsersRead, Scope{ClassID: 2}, false},
+		{"course without class needs a course assignment", instructor.ID, PermScoresExport, Scope{CourseID: 3}, true},
+		{"class assignment does not grant a course alone", instructor.ID, PermScoresExport, Scope{CourseID: 5}, false},
+		{"student of an assigned class", instructor.ID, PermUsersWrite, Scope{UserID: studentA.ID}, true},
+		{"student of an unassigned class", assistant.ID, PermUsersRead, Scope{UserID: studentB.ID}, false},
+		{"student of a class assigned for another course", instructor.ID, PermUsersWrite, Scope{UserID: studentB.ID, CourseID: 4}, false},
+		{"staff target refused", instructor.ID, PermUsersWrite, Scope{UserID: otherInstructor.ID}, false},
+		{"admin target refused", instructor.ID, PermUsersWrite, Scope{UserID: admin.ID}, false},
+	}
+
+	for _, tc := range cases {
+		allowed, err := HasPermission(tc.userId, tc.permission, tc.scope)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if allowed != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, allowed)
+		}
+	}
+
+	if _, err := HasPermission(instructor.ID, PermUsersWrite, Scope{UserID: 999}); err != ErrNotFound {
+		t.Errorf("unknown target: expected %v, got %v", ErrNotFound, err)
+	}
+	if _, err := HasPermission(999, PermUsersRead, Scope{}); err != ErrNotFound {
+		t.Errorf("unknown user: expected %v, got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"sort"
+)
+
+// scoring policies deriving the score of a lab from its attempts
+const (
+	PolicyHighest = "highest"
+	PolicyLatest  = "latest"
+	PolicyFirst   = "first"
+	PolicyAverage = "average"
+	// PolicyBestOfN is the average of the N highest attempts
+	PolicyBestOfN = "best_of_n"
+)
+
+var ErrUnsupportedPolicy = errors.New("unsupported scoring policy")
+
+// ScoringConfig is a struct to store scoring configuration
+type ScoringConfig struct {
+	// Policy used by labs and courses without their own policy, highest by default
+	Policy string
+	// PolicyN is the N of best_of_n when not set on the lab or course
+	PolicyN int
+	// UnlockOverrides let a new attempt replace a manual score, unless the override is locked
+	UnlockOverrides bool
+}
+
+var scoringConfig = ScoringConfig{
+	Policy:  PolicyHighest,
+	PolicyN: 3,
+}
+
+// InitScoring set the scoring configuration, zero values keep the default
+func (config ScoringConfig) InitScoring() error {
+	if config.Policy == "" {
+		config.Policy = scoringConfig.Policy
+	}
+	if config.PolicyN == 0 {
+		config.PolicyN = scoringConfig.PolicyN
+	}
+	if err := validatePolicy(config.Policy, config.PolicyN); err != nil {
+		return err
+	}
+
+	scoringConfig = config
+	return nil
+}
+
+// validatePolicy check policy name and n, the empty policy inherit from the course or configuration
+func validatePolicy(policy string, n int) error {
+	switch policy {
+	case "", PolicyHighest, PolicyLatest, PolicyFirst, PolicyAverage:
+		return nil
+	case PolicyBestOfN:
+		if n < 0 {
+			return ErrUnsupportedPolicy
+		}
+		return nil
+	default:
+		return ErrUnsupportedPolicy
+	}
+}
+
+// resolvePolicy returns the policy of lab, falling back to its course then to the configuration
+func resolvePolicy(lab Lab, course Course) (policy string, n int) {
+	policy, n = lab.ScoringPolicy, lab.PolicyN
+	if policy == "" {
+		policy, n = course.ScoringPolicy, course.PolicyN
+	}
+	if policy == "" {
+		policy = scoringConfig.Policy
+	}
+	if n == 0 {
+		n = scoringConfig.PolicyN
+	}
+
+	return policy, n
+}
+
+// effectiveScore apply policy on attempts ordered from oldest to newest, averages
+// are not rounded
+func effectiveScore(policy string, n int, attempts []Attempt) float64 {
+	if len(attempts) == 0 {
+		return 0
+	}
+
+	switch policy {
+	case PolicyLatest:
+		return attempts[len(attempts)-1].Score
+	case PolicyFirst:
+		return attempts[0].Score
+	case PolicyAverage:
+		return averageScore(attempts)
+	case PolicyBestOfN:
+		best := make([]Attempt, len(attempts))
+		copy(best, attempts)
+		sort.SliceStable(best, func(i, j int) bool { return best[i].Score > best[j].Score })
+		if n > 0 && n < len(best) {
+			best = best[:n]
+		}
+		return averageScore(best)
+	default:
+		highest := attempts[0].Score
+		for _, attempt := range attempts[1:] {
+			if attempt.Score > highest {
+				highest = attempt.Score
+			}
+		}
+		return highest
+	}
+}
+
+// decidingAttempt returns the index of the attempt the score comes from, the
+// latest attempt for policies mixing several attempts
+func decidingAttempt(policy string, attempts []Attempt) int {
+	switch policy {
+	case PolicyFirst:
+		return 0
+	case PolicyHighest:
+		best := 0
+		for i, attempt := range attempts {
+			if attempt.Score > attempts[best].Score {
+				best = i
+			}
+		}
+		return best
+	default:
+		return len(attempts) - 1
+	}
+}
+
+// averageScore returns the mean of attempts
+func averageScore(attempts []Attempt) float64 {
+	var sum float64
+	for _, attempt := range attempts {
+		sum += attempt.Score
+	}
+
+	return sum / float64(len(attempts))
+}
+
+// roundScore round score to a whole number, or to two decimals when fraction is allowed
+func roundScore(score float64, fraction bool) float64 {
+	if fraction {
+		return math.Round(score*100) / 100
+	}
+	return math.Round(score)
+}
+
+// recomputeScore derive the score row of userId on labId from its attempts, late
+// attempts lose their penalty first, it returns whether the row was created or changed
+func recomputeScore(tx *gorm.DB, userId int, labId int) (bool, error) {
+	var lab Lab
+	if err := tx.Preload("Course").Where("id = ?", labId).First(&lab).Error; err != nil {
+		return false, ErrNotFound
+	}
+	policy, n := resolvePolicy(lab, lab.Course)
+
+	var user User
+	if err := tx.Where("id = ?", userId).First(&user).Error; err != nil {
+		return false, ErrNotFound
+	}
+	window, err := resolveWindow(tx, lab, user.ClassID)
+	if err != nil {
+		return false, err
+	}
+
+	var attempts []Attempt
+	res := tx.Where("user_id = ? AND lab_id = ?", userId, labId).Order("created_at, id").Find(&attempts)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	var scores Score
+	found := tx.Where("user_id = ? AND lab_id = ?", userId, labId).Limit(1).Find(&scores).RowsAffected > 0
+
+	// without attempt there is nothing to derive, e.g. every attempt was removed,
+	// unless the score was set manually
+	if len(attempts) == 0 && scores.OverrideAction == "" {
+		if found {
+			return true, tx.Delete(&scores).Error
+		}
+		return false, nil
+	}
+
+	var effective, penalty float64
+	var attemptId *int
+	if len(attempts) > 0 {
+		// the penalty of an attempt change with the due date, keep it up to date for the attempt log
+		penalized := make([]Attempt, len(attempts))
+		for i, attempt := range attempts {
+			attemptPenalty := latePenalty(lab, window, attempt.Score, attempt.CreatedAt)
+			if attemptPenalty != attempt.Penalty {
+				if err := tx.Model(&Attempt{}).Where("id = ?", attempt.ID).Update("penalty", attemptPenalty).Error; err != nil {
+					return false, err
+				}
+			}
+			penalized[i] = attempt
+			penalized[i].Score -= attemptPenalty
+		}
+
+		effective = roundScore(effectiveScore(policy, n, penalized), lab.AllowFraction)
+		penalty = roundScore(effectiveScore(policy, n, attempts), lab.AllowFraction) - effective
+		if penalty < 0 {
+			penalty = 0
+		}
+		attemptId = &penalized[decidingAttempt(policy, penalized)].ID
+	}
+
+	final := applyOverride(scores, effective, lab.MaxScore)
+
+	// a concurrent first push may have created the score since, there is no row to lock before
+	if !found {
+		return true, tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "lab_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"score", "penalty", "attempt_id", "updated_at"}),
+		}).Create(&Score{UserID: userId, LabID: labId, Score: final, Penalty: penalty, AttemptID: attemptId}).Error
+	}
+
+	if scores.Score != final || scores.Penalty != penalty || !sameAttempt(scores.AttemptID, attemptId) {
+		changed := scores.Score != final || scores.Penalty != penalty
+		scores.Score = final
+		scores.Penalty = penalty
+		scores.AttemptID = attemptId
+		return changed, tx.Save(&scores).Error
+	}
+
+	return false, nil
+}
+
+func sameAttempt(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// recomputeLabScores derive again every score of labIds, used when their policy change
+func recomputeLabScores(tx *gorm.DB, labIds []int) error {
+	var pairs []struct {
+		UserID int
+		LabID  int
+	}
+	res := tx.Model(&Attempt{}).Distinct("user_id", "lab_id").Where("lab_id IN ?", labIds).Scan(&pairs)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	for _, pair := range pairs {
+		if _, err := recomputeScore(tx, pair.UserID, pair.LabID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
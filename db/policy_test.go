@@ -0,0 +1,73 @@
+package db
+
+import "testing"
+
+// TestEffectiveScore verifies the score derived from attempts ordered from oldest to newest
+func TestEffectiveScore(t *testing.T) {
+	attempts := []Attempt{{Score: 60}, {Score: 90}, {Score: 75}, {Score: 80}}
+
+	tests := []struct {
+		policy   string
+		n        int
+		expected float64
+	}{
+		{PolicyHighest, 0, 90},
+		{PolicyLatest, 0, 80},
+		{PolicyFirst, 0, 60},
+		{PolicyAverage, 0, 76},
+		{PolicyBestOfN, 3, 82},
+		{PolicyBestOfN, 10, 76},
+	}
+
+	for _, test := range tests {
+		if score := roundScore(effectiveScore(test.policy, test.n, attempts), false); score != test.expected {
+			t.Errorf("%s of %d: expected %v, got %v", test.policy, test.n, test.expected, score)
+		}
+	}
+
+	if score := effectiveScore(PolicyHighest, 0, nil); score != 0 {
+		t.Errorf("no attempt: expected 0, got %v", score)
+	}
+
+	// labs allowing fractions keep two decimals
+	if score := roundScore(effectiveScore(PolicyAverage, 0, attempts[:3]), true); score != 75 {
+		t.Errorf("fraction average: expected 75, got %v", score)
+	}
+	if score := roundScore(effectiveScore(PolicyAverage, 0, []Attempt{{Score: 7.5}, {Score: 8}, {Score: 9}}), true); score != 8.17 {
+		t.Errorf("fraction average: expected 8.17, got %v", score)
+	}
+}
+
+// TestResolvePolicy verifies the lab policy falls back to the course then to the configuration
+func TestResolvePolicy(t *testing.T) {
+	course := Course{ScoringPolicy: PolicyBestOfN, PolicyN: 2}
+
+	if policy, _ := resolvePolicy(Lab{ScoringPolicy: PolicyFirst}, course); policy != PolicyFirst {
+		t.Errorf("expected lab policy first, got %s", policy)
+	}
+	if policy, n := resolvePolicy(Lab{}, course); policy != PolicyBestOfN || n != 2 {
+		t.Errorf("expected course policy best_of_n of 2, got %s of %d", policy, n)
+	}
+	if policy, _ := resolvePolicy(Lab{}, Course{}); policy != scoringConfig.Policy {
+		t.Errorf("expected configured policy %s, got %s", scoringConfig.Policy, policy)
+	}
+
+	if err := validatePolicy("median", 0); err != ErrUnsupportedPolicy {
+		t.Errorf("expected ErrUnsupportedPolicy, got %v", err)
+	}
+}
+
+// TestDecidingAttempt verifies the attempt the score comes from
+func TestDecidingAttempt(t *testing.T) {
+	attempts := []Attempt{{Score: 60}, {Score: 90}, {Score: 75}}
+
+	if i := decidingAttempt(PolicyHighest, attempts); i != 1 {
+		t.Errorf("highest: expected 1, got %d", i)
+	}
+	if i := decidingAttempt(PolicyFirst, attempts); i != 0 {
+		t.Errorf("first: expected 0, got %d", i)
+	}
+	if i := decidingAttempt(PolicyAverage, attempts); i != 2 {
+		t.Errorf("average: expected 2, got %d", i)
+	}
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+var (
+	ErrLabNotOpen      = errors.New("lab is not open yet")
+	ErrLabClosed       = errors.New("lab is closed")
+	ErrInvalidSchedule = errors.New("invalid lab schedule")
+)
+
+// LabWindowError is returned when a score is pushed outside the window of the lab,
+// At is when the lab opens or closed
+type LabWindowError struct {
+	Err error
+	At  time.Time
+}
+
+func (e *LabWindowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LabWindowError) Unwrap() error {
+	return e.Err
+}
+
+// labWindow is the availability window of a lab for a class, nil times are unbounded
+type labWindow struct {
+	OpensAt  *time.Time
+	DueAt    *time.Time
+	ClosesAt *time.Time
+}
+
+// validateWindow check the window times are in order
+func validateWindow(window labWindow) error {
+	times := []*time.Time{window.OpensAt, window.DueAt, window.ClosesAt}
+	var previous *time.Time
+	for _, t := range times {
+		if t == nil {
+			continue
+		}
+		if previous != nil && t.Before(*previous) {
+			return ErrInvalidSchedule
+		}
+		previous = t
+	}
+
+	return nil
+}
+
+// validateLatePenalty check the late penalty rules of lab
+func validateLatePenalty(lab Lab) error {
+	if lab.LatePenaltyPerDay < 0 || lab.LatePenaltyPerDay > 100 ||
+		lab.LatePenaltyCap < 0 || lab.LatePenaltyCap > 100 || lab.LateGraceMinutes < 0 {
+		return ErrInvalidSchedule
+	}
+
+	return validateWindow(labWindow{lab.OpensAt, lab.DueAt, lab.ClosesAt})
+}
+
+// scoringChanged returns whether the scores of a lab must be derived again after its update
+func scoringChanged(old Lab, lab Lab) bool {
+	return old.ScoringPolicy != lab.ScoringPolicy || old.PolicyN != lab.PolicyN ||
+		!sameTime(old.DueAt, lab.DueAt) || old.LatePenaltyPerDay != lab.LatePenaltyPerDay ||
+		old.LatePenaltyCap != lab.LatePenaltyCap || old.LateGraceMinutes != lab.LateGraceMinutes ||
+		old.MaxScore != lab.MaxScore || old.AllowFraction != lab.AllowFraction
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// resolveWindow returns the window of lab for classId, a class schedule replace the lab window
+func resolveWindow(tx *gorm.DB, lab Lab, classId int) (labWindow, error) {
+	var schedule LabSchedule
+	res := tx.Where("lab_id = ? AND class_id = ?", lab.ID, classId).Limit(1).Find(&schedule)
+	if res.Error != nil {
+		return labWindow{}, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return labWindow{schedule.OpensAt, schedule.DueAt, schedule.ClosesAt}, nil
+	}
+
+	return labWindow{lab.OpensAt, lab.DueAt, lab.ClosesAt}, nil
+}
+
+// checkWindow refuse a push at now outside window
+func checkWindow(window labWindow, now time.Time) error {
+	if window.OpensAt != nil && now.Before(*window.OpensAt) {
+		return &LabWindowError{Err: ErrLabNotOpen, At: *window.OpensAt}
+	}
+	if window.ClosesAt != nil && now.After(*window.ClosesAt) {
+		return &LabWindowError{Err: ErrLabClosed, At: *window.ClosesAt}
+	}
+
+	return nil
+}
+
+// latePenalty returns the points removed from score pushed at pushedAt, every
+// started day after the due date and grace period costs LatePenaltyPerDay percent
+// up to LatePenaltyCap percent
+func latePenalty(lab Lab, window labWindow, score float64, pushedAt time.Time) float64 {
+	if window.DueAt == nil || lab.LatePenaltyPerDay == 0 {
+		return 0
+	}
+
+	late := pushedAt.Sub(window.DueAt.Add(time.Duration(lab.LateGraceMinutes) * time.Minute))
+	if late <= 0 {
+		return 0
+	}
+
+	days := int(math.Ceil(late.Hours() / 24))
+	percent := days * lab.LatePenaltyPerDay
+	if limit := lab.LatePenaltyCap; limit == 0 && percent > 100 {
+		percent = 100
+	} else if limit > 0 && percent > limit {
+		percent = limit
+	}
+
+	return roundScore(score*float64(percent)/100, lab.AllowFraction)
+}
+
+// SetLabSchedule is a function to set the window of a lab for a class, replacing the previous one
+func SetLabSchedule(schedule LabSchedule) (LabSchedule, error) {
+	if schedule.LabID == 0 || schedule.ClassID == 0 {
+		return schedule, ErrCantBeEmpty
+	}
+	if err := validateWindow(labWindow{schedule.OpensAt, schedule.DueAt, schedule.ClosesAt}); err != nil {
+		return schedule, err
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var old LabSchedule
+		found := tx.Where("lab_id = ? AND class_id = ?", schedule.LabID, schedule.ClassID).Limit(1).Find(&old).RowsAffected > 0
+		if found {
+			schedule.ID = old.ID
+		}
+		if err := tx.Save(&schedule).Error; err != nil {
+			return err
+		}
+
+		return recomputeClassScores(tx, schedule.LabID, schedule.ClassID)
+	})
+	if err != nil {
+		return schedule, err
+	}
+
+	return schedule, nil
+}
+
+// GetLabSchedules is a function to get the class schedules of labId
+func GetLabSchedules(labId int) ([]LabSchedule, error) {
+	var schedules []LabSchedule
+	if err := DB.Where("lab_id = ?", labId).Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// DeleteLabSchedule is a function to remove the schedule of labId for classId, the lab window apply again
+func DeleteLabSchedule(labId int, classId int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("lab_id = ? AND class_id = ?", labId, classId).Delete(&LabSchedule{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return recomputeClassScores(tx, labId, classId)
+	})
+}
+
+// recomputeClassScores derive again the scores of students of classId on labId
+func recomputeClassScores(tx *gorm.DB, labId int, classId int) error {
+	var userIds []int
+	res := tx.Model(&Attempt{}).Distinct("attempts.user_id").
+		Joins("JOIN users ON users.id = attempts.user_id").
+		Where("attempts.lab_id = ? AND users.class_id = ?", labId, classId).
+		Pluck("attempts.user_id", &userIds)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	for _, userId := range userIds {
+		if _, err := recomputeScore(tx, userId, labId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
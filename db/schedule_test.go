@@ -0,0 +1,56 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestLatePenalty verifies the penalty of every started day after the due date and grace period
+func TestLatePenalty(t *testing.T) {
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	window := labWindow{DueAt: &due}
+	lab := Lab{LatePenaltyPerDay: 10, LatePenaltyCap: 25, LateGraceMinutes: 30}
+
+	tests := []struct {
+		pushedAt time.Time
+		expected float64
+	}{
+		{due.Add(-time.Hour), 0},
+		{due.Add(20 * time.Minute), 0},
+		{due.Add(time.Hour), 8},
+		{due.Add(30*time.Minute + 25*time.Hour), 16},
+		{due.Add(10 * 24 * time.Hour), 20},
+	}
+
+	for _, test := range tests {
+		if penalty := latePenalty(lab, window, 80, test.pushedAt); penalty != test.expected {
+			t.Errorf("pushed at %s: expected %v, got %v", test.pushedAt, test.expected, penalty)
+		}
+	}
+
+	if penalty := latePenalty(Lab{LatePenaltyPerDay: 10}, labWindow{}, 80, due); penalty != 0 {
+		t.Errorf("without due date: expected 0, got %v", penalty)
+	}
+}
+
+// TestCheckWindow verifies pushes before opens_at and after closes_at are refused
+func TestCheckWindow(t *testing.T) {
+	opens := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	closes := opens.Add(7 * 24 * time.Hour)
+	window := labWindow{OpensAt: &opens, ClosesAt: &closes}
+
+	if err := checkWindow(window, opens.Add(-time.Minute)); !errors.Is(err, ErrLabNotOpen) {
+		t.Errorf("expected ErrLabNotOpen, got %v", err)
+	}
+	if err := checkWindow(window, opens.Add(time.Hour)); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := checkWindow(window, closes.Add(time.Minute)); !errors.Is(err, ErrLabClosed) {
+		t.Errorf("expected ErrLabClosed, got %v", err)
+	}
+
+	if err := validateWindow(labWindow{OpensAt: &closes, ClosesAt: &opens}); err != ErrInvalidSchedule {
+		t.Errorf("expected ErrInvalidSchedule, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build cgo
+
+package db
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+// legacyScore is the scores table before scores were unique per student and lab
+type legacyScore struct {
+	ID        int
+	UserID    int
+	LabID     int
+	Score     float64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (legacyScore) TableName() string {
+	return "scores"
+}
+
+// TestDedupeScores verifies the last updated duplicate is kept so the unique index can be created
+func TestDedupeScores(t *testing.T) {
+	previous := DB
+	defer func() { DB = previous }()
+
+	var err error
+	DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.AutoMigrate(&legacyScore{}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	legacy := []legacyScore{
+		{UserID: 1, LabID: 1, Score: 60, UpdatedAt: now.Add(-time.Hour)},
+		{UserID: 1, LabID: 1, Score: 80, UpdatedAt: now},
+		{UserID: 1, LabID: 2, Score: 70, UpdatedAt: now},
+	}
+	if err := DB.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dedupeScores(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.AutoMigrate(&Score{}); err != nil {
+		t.Fatalf("expected the unique index to be created, got %v", err)
+	}
+
+	var scores []Score
+	DB.Order("lab_id").Find(&scores)
+	if len(scores) != 2 || scores[0].Score != 80 || scores[1].Score != 70 {
+		t.Errorf("expected the last score of each lab kept, got %+v", scores)
+	}
+
+	if err := DB.Create(&Score{UserID: 1, LabID: 1, Score: 90}).Error; err == nil {
+		t.Error("expected a second score of the same student and lab to be refused")
+	}
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"time"
+)
+
+// hashToken returns the hex encoded sha256 of a token, refresh tokens are
+// random 256-bit values so a fast hash is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken generate url safe random string from n random bytes
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateRefreshToken is a function to create and persist a refresh token for userId,
+// mfa records if the login completed the second factor
+func CreateRefreshToken(userId int, mfa bool, ttl time.Duration) (string, error) {
+	return createRefreshToken(DB, userId, mfa, ttl)
+}
+
+func createRefreshToken(tx *gorm.DB, userId int, mfa bool, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	res := tx.Create(&RefreshToken{
+		UserID:    userId,
+		TokenHash: hashToken(token),
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if res.Error != nil {
+		return "", res.Error
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken is a function to exchange a refresh token with a new one,
+// the old token is revoked. Presenting an already revoked token revoke every
+// session of the user because the token has been leaked. The mfa flag is kept by the new token
+func RotateRefreshToken(token string, refreshTTL time.Duration) (userId int, mfa bool, newToken string, err error) {
+	if token == "" {
+		return 0, false, "", ErrCantBeEmpty
+	}
+
+	var refresh RefreshToken
+	if err := DB.Where("token_hash = ?", hashToken(token)).First(&refresh).Error; err != nil {
+		return 0, false, "", ErrNotFound
+	}
+
+	if refresh.RevokedAt != nil {
+		if err := RevokeUserSessions(refresh.UserID); err != nil {
+			return 0, false, "", err
+		}
+		return 0, false, "", ErrTokenRevoked
+	}
+
+	if time.Now().After(refresh.ExpiresAt) {
+		return 0, false, "", ErrTokenExpired
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// only revoke when still active, a concurrent rotation must not win twice
+		res := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", refresh.ID).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTokenRevoked
+		}
+
+		newToken, err = createRefreshToken(tx, refresh.UserID, refresh.MFA, refreshTTL)
+		return err
+	})
+	if err != nil {
+		return 0, false, "", err
+	}
+
+	return refresh.UserID, refresh.MFA, newToken, nil
+}
+
+// RevokeRefreshToken is a function to revoke a refresh token owned by userId
+func RevokeRefreshToken(userId int, token string) error {
+	res := DB.Model(&RefreshToken{}).
+		Where("token_hash = ? AND user_id = ? AND revoked_at IS NULL", hashToken(token), userId).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeAccessToken is a function to add a single access token to the revocation table
+func RevokeAccessToken(userId int, tokenId string, expiresAt time.Time) error {
+	if tokenId == "" {
+		return ErrCantBeEmpty
+	}
+
+	res := DB.Create(&RevokedToken{
+		UserID:    userId,
+		TokenID:   tokenId,
+		ExpiresAt: expiresAt,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	purgeRevokedTokens()
+
+	return nil
+}
+
+// RevokeUserSessions is a function to revoke every refresh token of userId and
+// every access token issued until now by increasing the user token version
+func RevokeUserSessions(userId int) error {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return ErrNotFound
+	}
+
+	return revokeUserSessions(DB, userId)
+}
+
+func revokeUserSessions(tx *gorm.DB, userId int) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userId).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Model(&User{}).
+			Where("id = ?", userId).
+			Update("token_version", gorm.Expr("token_version + 1")).Error
+	})
+}
+
+// IsTokenRevoked check the revocation table and the user token version for an access token
+func IsTokenRevoked(userId int, tokenId string, tokenVersion int) (bool, error) {
+	var count int64
+	res := DB.Model(&User{}).Where("id = ? AND token_version = ?", userId, tokenVersion).Count(&count)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if count == 0 {
+		return true, nil
+	}
+
+	res = DB.Model(&RevokedToken{}).Where("token_id = ?", tokenId).Count(&count)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return count > 0, nil
+}
+
+// purgeRevokedTokens remove revocation entries and refresh tokens that can not be used anymore
+func purgeRevokedTokens() {
+	DB.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+	DB.Where("expires_at < ?", time.Now()).Delete(&RefreshToken{})
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSignatureRequired = errors.New("signature required")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrSignatureExpired  = errors.New("signature timestamp out of window")
+	ErrNonceReused       = errors.New("nonce already used")
+)
+
+// SigningConfig is a struct to store signed score submission configuration
+type SigningConfig struct {
+	// Skew is how far the timestamp of a submission can be from the server time, nonces
+	// are kept twice as long
+	Skew time.Duration
+}
+
+var signingConfig = SigningConfig{
+	Skew: 5 * time.Minute,
+}
+
+// InitSigning set the signing configuration, zero values keep the default
+func (config SigningConfig) InitSigning() {
+	if config.Skew <= 0 {
+		config.Skew = signingConfig.Skew
+	}
+
+	signingConfig = config
+}
+
+// scoreSignature returns the hex encoded HMAC-SHA256 of score with secret, fields
+// are joined with new lines in a fixed order
+func scoreSignature(secret string, score ScorePush) string {
+	// the shortest decimal form keep whole scores signed as integers
+	value := strconv.FormatFloat(score.Score, 'f', -1, 64)
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n%d", score.Username, score.Lab, value, score.Nonce, score.Timestamp)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyScoreSignature check the signature of score with the lab secret, or the course
+// secret when the lab has none, unsigned scores are accepted unless the course requires
+// signatures
+func verifyScoreSignature(lab Lab, score ScorePush, now time.Time) error {
+	var course Course
+	if err := DB.Where("id = ?", lab.CourseID).First(&course).Error; err != nil {
+		return ErrNotFound
+	}
+
+	secret := lab.SigningSecret
+	if secret == "" {
+		secret = course.SigningSecret
+	}
+
+	if score.Signature == "" {
+		if course.RequireSignature {
+			return ErrSignatureRequired
+		}
+		return nil
+	}
+
+	if secret == "" || score.Nonce == "" {
+		return ErrInvalidSignature
+	}
+
+	expected := scoreSignature(secret, score)
+	if !hmac.Equal([]byte(expected), []byte(score.Signature)) {
+		return ErrInvalidSignature
+	}
+
+	signedAt := time.Unix(score.Timestamp, 0)
+	if signedAt.Before(now.Add(-signingConfig.Skew)) || signedAt.After(now.Add(signingConfig.Skew)) {
+		return ErrSignatureExpired
+	}
+
+	return useNonce(score.Nonce, now)
+}
+
+// useNonce store nonce, a nonce seen in the last two skew windows is refused
+func useNonce(nonce string, now time.Time) error {
+	// older nonces can not be replayed anyway, their timestamp is out of window
+	res := DB.Where("created_at < ?", now.Add(-2*signingConfig.Skew)).Delete(&ScoreNonce{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	res = DB.Where("nonce = ?", hashToken(nonce)).Limit(1).Find(&ScoreNonce{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return ErrNonceReused
+	}
+
+	// the primary key refuse a concurrent request with the same nonce
+	if err := DB.Create(&ScoreNonce{Nonce: hashToken(nonce), CreatedAt: now}).Error; err != nil {
+		return ErrNonceReused
+	}
+
+	return nil
+}
+
+// RotateLabSecret is a function to generate a new signing secret for labId
+func RotateLabSecret(labId int) (string, error) {
+	if err := DB.Where("id = ?", labId).First(&Lab{}).Error; err != nil {
+		return "", ErrNotFound
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	res := DB.Model(&Lab{}).Where("id = ?", labId).Update("signing_secret", secret)
+	if res.Error != nil {
+		return "", res.Error
+	}
+
+	return secret, nil
+}
+
+// ClearLabSecret is a function to remove the signing secret of labId, the course secret is then used
+func ClearLabSecret(labId int) error {
+	res := DB.Model(&Lab{}).Where("id = ?", labId).Update("signing_secret", "")
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RotateCourseSecret is a function to generate a new signing secret for courseId
+func RotateCourseSecret(courseId int) (string, error) {
+	if err := DB.Where("id = ?", courseId).First(&Course{}).Error; err != nil {
+		return "", ErrNotFound
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	res := DB.Model(&Course{}).Where("id = ?", courseId).Update("signing_secret", secret)
+	if res.Error != nil {
+		return "", res.Error
+	}
+
+	return secret, nil
+}
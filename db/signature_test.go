@@ -0,0 +1,29 @@
+package db
+
+import "testing"
+
+// TestScoreSignature verifies every signed field changes the signature
+func TestScoreSignature(t *testing.T) {
+	score := ScorePush{Username: "student1", Lab: "lab-1", Score: 60, Nonce: "n1", Timestamp: 1700000000}
+	signature := scoreSignature("secret", score)
+
+	if signature != scoreSignature("secret", score) {
+		t.Fatal("expected a stable signature")
+	}
+
+	forged := score
+	forged.Score = 100
+	if scoreSignature("secret", forged) == signature {
+		t.Error("expected another signature for another score")
+	}
+	if scoreSignature("other", score) == signature {
+		t.Error("expected another signature with another secret")
+	}
+
+	// fields are separated so they can not be shifted
+	shifted := score
+	shifted.Username, shifted.Lab = "student1\nlab-1", ""
+	if scoreSignature("secret", shifted) == signature {
+		t.Error("expected another signature for shifted fields")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build cgo
+
+// The database of these tests is the cgo SQLite driver, the other tests of the package run without cgo
+
+package db
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+// openTestDB replace DB with an in-memory database with the schema of the migration and the default roles
+func openTestDB(t *testing.T) {
+	previous := DB
+	t.Cleanup(func() { DB = previous })
+
+	// a shared cache lets queries outside a transaction run while it is open, e.g. HasPermission
+	dsn := "file:" + t.Name() + "?mode=memory&cache=shared"
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := conn.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	DB = conn
+	if err := DB.AutoMigrate(append([]interface{}{&Class{}}, models...)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedRoles(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestUser create username with role in classId
+func createTestUser(t *testing.T, username string, role string, classId int) User {
+	roleId, err := GetRoleIdByName(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Username: username, Password: "-", Name: username, RoleID: roleId, ClassID: classId}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// createTestLab create a class, a course and a lab of the course
+func createTestLab(t *testing.T, class string, course string, lab string) (Class, Course, Lab) {
+	c := Class{Name: class}
+	if err := DB.Create(&c).Error; err != nil {
+		t.Fatal(err)
+	}
+	co := Course{Name: course}
+	if err := DB.Create(&co).Error; err != nil {
+		t.Fatal(err)
+	}
+	l := Lab{Name: lab, CourseID: co.ID, MaxScore: 100}
+	if err := DB.Create(&l).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return c, co, l
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 understood by every authenticator app
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods accepted before and after the current one
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidMFACode     = errors.New("invalid mfa code")
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp not enrolled")
+	ErrTOTPNotEnabled     = errors.New("totp not enabled")
+)
+
+var (
+	// totpSecretEncoding is the unpadded base32 expected in otpauth uris
+	totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	// recoveryCodeFormatting remove the separators users may type
+	recoveryCodeFormatting = strings.NewReplacer("-", "", " ", "")
+)
+
+// hotp returns the RFC 4226 code of secret for counter
+func hotp(secret []byte, counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// matchTOTP returns the counter matching code around now, only counters after
+// lastCounter are accepted so a code can not be replayed
+func matchTOTP(secret string, code string, now time.Time, lastCounter int64) (int64, bool) {
+	key, err := totpSecretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(counter))), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpURI returns the otpauth uri shown as QR code by the client
+func totpURI(issuer, username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// BeginTOTPEnrollment is a function to generate a new TOTP secret for userId, the
+// secret is only used once confirmed with ConfirmTOTPEnrollment
+func BeginTOTPEnrollment(userId int, issuer string) (secret string, uri string, err error) {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return "", "", ErrNotFound
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	secret = totpSecretEncoding.EncodeToString(key)
+
+	res := DB.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+		"totp_secret":       secret,
+		"totp_last_counter": 0,
+	})
+	if res.Error != nil {
+		return "", "", res.Error
+	}
+
+	return secret, totpURI(issuer, user.Username, secret), nil
+}
+
+// ConfirmTOTPEnrollment is a function to enable TOTP of userId with a code of the
+// pending secret, it returns the recovery codes which are only stored hashed
+func ConfirmTOTPEnrollment(userId int, code string) (recoveryCodes []string, err error) {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	counter, ok := matchTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+			"totp_enabled":      true,
+			"totp_last_counter": counter,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		recoveryCodes, err = createRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// createRecoveryCodes replace the recovery codes of userId
+func createRecoveryCodes(tx *gorm.DB, userId int) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpSecretEncoding.EncodeToString(raw))
+		codes = append(codes, code[:4]+"-"+code[4:])
+
+		res := tx.Create(&RecoveryCode{UserID: userId, CodeHash: hashToken(code)})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+	}
+
+	return codes, nil
+}
+
+// VerifyMFA is a function to check a TOTP code or an unused recovery code of userId
+func VerifyMFA(userId int, code string) error {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return ErrNotFound
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	code = recoveryCodeFormatting.Replace(strings.TrimSpace(code))
+
+	if counter, ok := matchTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter); ok {
+		// concurrent requests with the same code must not both succeed
+		res := DB.Model(&User{}).
+			Where("id = ? AND totp_last_counter < ?", userId, counter).
+			Update("totp_last_counter", counter)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	res := DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hashToken(strings.ToLower(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// DisableTOTP is a function to disable TOTP of userId after checking a code,
+// the recovery codes are removed
+func DisableTOTP(userId int, code string) error {
+	if err := VerifyMFA(userId, code); err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+			"totp_enabled":      false,
+			"totp_secret":       "",
+			"totp_last_counter": 0,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// IsTOTPEnabled is a function to check if userId must give a TOTP code on login
+func IsTOTPEnabled(userId int) (bool, error) {
+	var user User
+	if err := DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return false, ErrNotFound
+	}
+
+	return user.TOTPEnabled, nil
+}
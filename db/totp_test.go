@@ -0,0 +1,67 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPVectors verifies codes against the SHA1 test vectors of RFC 6238, truncated to 6 digits
+func TestTOTPVectors(t *testing.T) {
+	secret := totpSecretEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, code := range vectors {
+		counter, ok := matchTOTP(secret, code, time.Unix(unix, 0), 0)
+		if !ok {
+			t.Errorf("%d: code %s should match", unix, code)
+		}
+		if counter != unix/totpPeriod {
+			t.Errorf("%d: expected counter %d, got %d", unix, unix/totpPeriod, counter)
+		}
+	}
+}
+
+// TestTOTPSkewAndReplay verifies the accepted window and that a used counter is refused
+func TestTOTPSkewAndReplay(t *testing.T) {
+	secret := totpSecretEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	// code of the previous period is still accepted, two periods ago is not
+	previous := hotp([]byte("12345678901234567890"), uint64(now.Unix()/totpPeriod-1))
+	if _, ok := matchTOTP(secret, previous, now, 0); !ok {
+		t.Error("code of previous period should match")
+	}
+	old := hotp([]byte("12345678901234567890"), uint64(now.Unix()/totpPeriod-2))
+	if _, ok := matchTOTP(secret, old, now, 0); ok {
+		t.Error("code older than the skew should not match")
+	}
+
+	counter, ok := matchTOTP(secret, "081804", now, 0)
+	if !ok {
+		t.Fatal("code should match")
+	}
+	if _, ok := matchTOTP(secret, "081804", now, counter); ok {
+		t.Error("code with an already used counter should not match")
+	}
+}
+
+// TestTOTPURI verifies the provisioning uri read by authenticator apps
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("Shopiea", "admin", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Shopiea:admin?") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Shopiea", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("uri %s is missing %s", uri, param)
+		}
+	}
+}
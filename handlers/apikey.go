@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// apiKeyRoutes are the only routes accepting an api key, with the permission the key role needs
+var apiKeyRoutes = map[string]string{
+	"POST /v1/score":      db.PermScoresPush,
+	"POST /v1/score/bulk": db.PermScoresPush,
+}
+
+// authenticateAPIKey continue the request when key is valid for the route, the
+// api key id is set instead of the user id
+func authenticateAPIKey(c *gin.Context, key string) {
+	permission, ok := apiKeyRoutes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key not allowed on this route"})
+		return
+	}
+
+	apiKey, err := db.AuthenticateAPIKey(key, permission)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidAPIKey:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		case db.ErrPermissionMissing:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key not allowed on this route"})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		}
+		return
+	}
+
+	c.Set("apiKeyId", apiKey.ID)
+
+	c.Next()
+}
+
+// CreateAPIKey is a function to create an api key for automated graders, the key is only shown once
+func CreateAPIKey(c *gin.Context) {
+	var request db.APIKeyRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	apiKey, key, err := db.CreateAPIKey(request)
+	if err != nil {
+		switch err {
+		case db.ErrCantBeEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "name and course_ids cant be empty",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Course Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      apiKey.ID,
+		"name":    apiKey.Name,
+		"prefix":  apiKey.Prefix,
+		"key":     key,
+		"message": "Success create api key!",
+	})
+	return
+}
+
+// GetAPIKeys is a function to get all api keys without their secret
+func GetAPIKeys(c *gin.Context) {
+	apiKeys, err := db.GetAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": apiKeys,
+	})
+	return
+}
+
+// RevokeAPIKey is a function to revoke api key by id from query
+func RevokeAPIKey(c *gin.Context) {
+	apiKeyId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "id must be integer",
+		})
+		return
+	}
+
+	err = db.RevokeAPIKey(apiKeyId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "API Key Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success revoke api key",
+	})
+	return
+}
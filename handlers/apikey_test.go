@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPIKeyRoutes verifies an api key is refused outside the allowed routes before
+// it is looked up, and a key without the shp_ prefix is refused on the allowed ones
+func TestAPIKeyRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.POST("/v1/score", AuthMiddleware(), ok)
+	router.GET("/v1/score", AuthMiddleware(), ok)
+	router.POST("/v1/admin/user", AuthMiddleware(), ok)
+
+	cases := []struct {
+		method   string
+		path     string
+		key      string
+		expected int
+	}{
+		{http.MethodGet, "/v1/score", "shp_prefix_secret", http.StatusForbidden},
+		{http.MethodPost, "/v1/admin/user", "shp_prefix_secret", http.StatusForbidden},
+		{http.MethodPost, "/v1/score", "prefix_secret", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/score", "shp_prefix", http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "ApiKey "+tc.key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("%s %s with %s: expected %d, got %d", tc.method, tc.path, tc.key, tc.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errArtifactTooLarge = errors.New("artifact too large")
+	errArtifactType     = errors.New("artifact content type not allowed")
+	errBlobNotFound     = errors.New("blob not found")
+)
+
+// maxScoreFieldSize is the size limit of the score field of a multipart push
+const maxScoreFieldSize = 1 << 20
+
+// BlobStore keeps the content of artifacts by key, the local filesystem is used by default
+// and s3BlobStore works with any S3-compatible storage, e.g. MinIO
+type BlobStore interface {
+	Put(key string, contentType string, data []byte) error
+	// Get returns errBlobNotFound when key does not exist
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// ArtifactConfig is a struct to store artifact upload configuration
+type ArtifactConfig struct {
+	// Store is local or s3
+	Store string
+	// Dir is the directory of the local store, default artifacts
+	Dir string
+	// MaxSize is the size limit of an artifact in bytes, default 5 MiB
+	MaxSize int64
+	// ContentTypes is the comma separated list of allowed content types, detected from the content
+	ContentTypes string
+	// Retention is how long artifacts are kept, default 90 days
+	Retention time.Duration
+	S3        S3Config
+}
+
+var defaultArtifactConfig = ArtifactConfig{
+	Store:        "local",
+	Dir:          "artifacts",
+	MaxSize:      5 << 20,
+	ContentTypes: "text/plain,application/json,application/x-gzip,application/x-tar,application/zip",
+	Retention:    90 * 24 * time.Hour,
+}
+
+var artifactConfig = defaultArtifactConfig
+
+var artifactTypes = parseContentTypes(defaultArtifactConfig.ContentTypes)
+
+var blobStore BlobStore = localBlobStore{dir: defaultArtifactConfig.Dir}
+
+// InitArtifacts set the artifact upload configuration, zero values keep the default
+func (config ArtifactConfig) InitArtifacts() error {
+	if config.Dir == "" {
+		config.Dir = defaultArtifactConfig.Dir
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultArtifactConfig.MaxSize
+	}
+	if config.ContentTypes == "" {
+		config.ContentTypes = defaultArtifactConfig.ContentTypes
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaultArtifactConfig.Retention
+	}
+
+	switch config.Store {
+	case "s3":
+		store, err := newS3BlobStore(config.S3)
+		if err != nil {
+			return err
+		}
+		blobStore = store
+	default:
+		config.Store = "local"
+		if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+			return err
+		}
+		blobStore = localBlobStore{dir: config.Dir}
+	}
+
+	artifactConfig = config
+	artifactTypes = parseContentTypes(config.ContentTypes)
+	return nil
+}
+
+// parseContentTypes parse a comma separated list of content types
+func parseContentTypes(list string) map[string]bool {
+	types := map[string]bool{}
+	for _, contentType := range strings.Split(list, ",") {
+		if contentType = strings.TrimSpace(contentType); contentType != "" {
+			types[contentType] = true
+		}
+	}
+
+	return types
+}
+
+// artifactUpload is an artifact read from the request, not stored yet
+type artifactUpload struct {
+	filename string
+	data     []byte
+}
+
+// bindScorePush read the score of a push, from the JSON body or from the score field of a
+// multipart body with an optional artifact file
+func bindScorePush(c *gin.Context) (db.ScorePush, *artifactUpload, error) {
+	var score db.ScorePush
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		err := c.ShouldBindJSON(&score)
+		return score, nil, err
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return score, nil, err
+	}
+
+	var upload *artifactUpload
+	var hasScore bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return score, nil, err
+		}
+
+		switch part.FormName() {
+		case "score":
+			if err := json.NewDecoder(io.LimitReader(part, maxScoreFieldSize)).Decode(&score); err != nil {
+				return score, nil, err
+			}
+			hasScore = true
+		case "artifact":
+			// read one more byte than allowed to know the limit is exceeded
+			data, err := io.ReadAll(io.LimitReader(part, artifactConfig.MaxSize+1))
+			if err != nil {
+				return score, nil, err
+			}
+			if int64(len(data)) > artifactConfig.MaxSize {
+				return score, nil, errArtifactTooLarge
+			}
+			if len(data) > 0 {
+				upload = &artifactUpload{filename: part.FileName(), data: data}
+			}
+		}
+		part.Close()
+	}
+
+	if !hasScore {
+		return score, nil, errors.New("multipart push without score field")
+	}
+
+	return score, upload, nil
+}
+
+// detectArtifactType returns the content type of data, sniffed from the content
+// and not from the type sent by the client
+func detectArtifactType(data []byte) string {
+	// tar archives are not detected by http.DetectContentType
+	if len(data) > 262 && string(data[257:262]) == "ustar" {
+		return "application/x-tar"
+	}
+
+	contentType := http.DetectContentType(data)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	if contentType == "text/plain" && json.Valid(data) {
+		return "application/json"
+	}
+
+	return contentType
+}
+
+// artifactFilename keep the base name of the uploaded file, used when downloading it
+func artifactFilename(filename string) string {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		return "artifact"
+	}
+	if len(filename) > 255 {
+		filename = filename[len(filename)-255:]
+	}
+
+	return filename
+}
+
+// storeArtifact check upload and save it to the blob store, the returned artifact is
+// attached to the attempt by db.PushScore
+func storeArtifact(upload *artifactUpload) (*db.Artifact, error) {
+	contentType := detectArtifactType(upload.data)
+	if !artifactTypes[contentType] {
+		return nil, errArtifactType
+	}
+
+	id, err := randomTokenId()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sum := sha256.Sum256(upload.data)
+	artifact := db.Artifact{
+		Key:         "attempts/" + now.Format("2006/01/02") + "/" + id,
+		Filename:    artifactFilename(upload.filename),
+		ContentType: contentType,
+		Size:        int64(len(upload.data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		ExpiresAt:   now.Add(artifactConfig.Retention),
+	}
+
+	if err := blobStore.Put(artifact.Key, artifact.ContentType, upload.data); err != nil {
+		return nil, err
+	}
+
+	return &artifact, nil
+}
+
+// purgeArtifacts delete artifacts older than the retention with their blob, a few at a time
+func purgeArtifacts(now time.Time) error {
+	artifacts, err := db.GetExpiredArtifacts(now, 100)
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		if err := blobStore.Delete(artifact.Key); err != nil && err != errBlobNotFound {
+			return err
+		}
+		if err := db.DeleteArtifact(artifact.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAttemptArtifact endpoint to download the artifact of attempt_id from query
+func GetAttemptArtifact(c *gin.Context) {
+	attemptId, err := strconv.Atoi(c.Query("attempt_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "attempt_id must be integer",
+		})
+		return
+	}
+
+	artifact, err := db.GetArtifact(attemptId)
+	if err != nil || artifact.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Artifact Not Found",
+		})
+		return
+	}
+
+	reader, err := blobStore.Get(artifact.Key)
+	if err != nil {
+		if err == errBlobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Artifact Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, artifact.Size, artifact.ContentType, reader, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Filename}),
+		"X-Content-Type-Options": "nosniff",
+		"X-Artifact-SHA256":      artifact.SHA256,
+	})
+	return
+}
+
+// localBlobStore is a BlobStore in a directory of the local filesystem
+type localBlobStore struct {
+	dir string
+}
+
+func (store localBlobStore) path(key string) string {
+	return filepath.Join(store.dir, filepath.FromSlash(key))
+}
+
+func (store localBlobStore) Put(key string, contentType string, data []byte) error {
+	path := store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a blob is never read half written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (store localBlobStore) Get(key string) (io.ReadCloser, error) {
+	file, err := os.Open(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+
+	return file, err
+}
+
+func (store localBlobStore) Delete(key string) error {
+	err := os.Remove(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return errBlobNotFound
+	}
+
+	return err
+}
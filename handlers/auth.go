@@ -1,17 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/Kyuubang/shopiea/db"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// Define the key to sign the JWT
-var jwtKey = []byte("my_secret_key")
-
 func Login(c *gin.Context) {
 	// Bind the JSON payload to a Login struct
 	var login db.Login
@@ -22,22 +21,62 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// refuse client ip with too many failed attempts
+	ip := c.ClientIP()
+	blockedUntil, err := ipBlockedUntil(ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !blockedUntil.IsZero() {
+		c.Header("Retry-After", retryAfter(blockedUntil))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many login attempts, try again later",
+		})
+		return
+	}
+
+	// refuse locked account
+	lockedUntil, err := userLockedUntil(login.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !lockedUntil.IsZero() {
+		c.Header("Retry-After", retryAfter(lockedUntil))
+		c.JSON(http.StatusLocked, gin.H{
+			"message": "Account is locked, try again later",
+		})
+		return
+	}
+
 	// login user
 	if userId, success := db.ValidationUserLogin(login); success {
-		// Generate a JWT token for the user ID
-		token, err := generateJWT(userId)
-		if err != nil {
+		if err := resetFailures(ipKey(ip), userKey(login.Username)); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "Internal Server Error",
 			})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Success login!",
-			"token":   token,
-		})
+		completeLogin(c, userId, "Success login!")
 		return
 	} else {
+		if err := recordIPFailure(ip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		if err := recordUserFailure(login.Username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"message": "Invalid username or password",
 		})
@@ -46,6 +85,116 @@ func Login(c *gin.Context) {
 
 }
 
+// Refresh exchange a refresh token with a new access and refresh token
+func Refresh(c *gin.Context) {
+	var request db.RefreshRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	userId, mfa, refreshToken, err := db.RotateRefreshToken(request.RefreshToken, refreshTokenTTL)
+	if err != nil {
+		switch err {
+		case db.ErrCantBeEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "refresh_token cant be empty",
+			})
+			return
+		case db.ErrNotFound, db.ErrTokenExpired, db.ErrTokenRevoked:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Invalid refresh token",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	writeTokens(c, strconv.Itoa(userId), mfa, refreshToken, "Success refresh!")
+	return
+}
+
+// Logout revoke the current access token and the refresh token from the body, if any
+func Logout(c *gin.Context) {
+	var request db.RefreshRequest
+	// the body is optional, logout without it only revoke the access token
+	_ = c.ShouldBindJSON(&request)
+
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	err = db.RevokeAccessToken(userIdInt, c.GetString("tokenId"), c.GetTime("tokenExpiresAt"))
+	if err != nil && !errors.Is(err, db.ErrCantBeEmpty) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if request.RefreshToken != "" {
+		err = db.RevokeRefreshToken(userIdInt, request.RefreshToken)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success logout!",
+	})
+	return
+}
+
+// RevokeUserSessions revoke every access and refresh token of user_id from query
+func RevokeUserSessions(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	err = db.RevokeUserSessions(userIdInt)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success revoke user sessions",
+	})
+	return
+}
+
+// passwordChangeRoutes are the routes allowed for a token with PasswordChange claim
+var passwordChangeRoutes = map[string]bool{
+	"/auth/logout":    true,
+	"/v1/auth/check":  true,
+	"/v1/me/password": true,
+}
+
 // AuthMiddleware function to authenticate JWT token
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -56,16 +205,58 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokens := strings.Split(tokenString, " ")
+		scheme, credential, ok := strings.Cut(tokenString, " ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+			return
+		}
+
+		// automated graders authenticate with an api key instead of a user token
+		if scheme == "ApiKey" {
+			authenticateAPIKey(c, credential)
+			return
+		}
 
 		// Verify the JWT token and get the user ID from it
-		userId, err := verifyJWT(tokens[1])
+		claims, err := verifyJWT(credential)
 		// check if token is valid
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token from middleware"})
 			return
 		}
-		c.Set("userId", userId)
+
+		// a login waiting for the second factor is not authenticated yet
+		if claims.Scope != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token from middleware"})
+			return
+		}
+
+		// check if token has been revoked by logout or by an admin
+		userIdInt, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token from middleware"})
+			return
+		}
+		revoked, err := db.IsTokenRevoked(userIdInt, claims.Id, claims.Version)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		// user with temporary password can only change it
+		if claims.PasswordChange && !passwordChangeRoutes[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Password change required"})
+			return
+		}
+
+		c.Set("userId", claims.Subject)
+		c.Set("tokenId", claims.Id)
+		c.Set("tokenExpiresAt", time.Unix(claims.ExpiresAt, 0))
+		c.Set("mfa", claims.MFA)
 
 		c.Next()
 	}
@@ -74,10 +265,21 @@ func AuthMiddleware() gin.HandlerFunc {
 func CheckToken(c *gin.Context) {
 	userId := c.MustGet("userId").(string)
 
+	userIdInt, _ := strconv.Atoi(userId)
+	role, permissions, err := db.GetPermissionNames(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Success check!",
-		"userId":  userId,
-		"admin":   db.IsAdmin(userId),
+		"message":     "Success check!",
+		"userId":      userId,
+		"admin":       role == db.RoleAdmin,
+		"role":        role,
+		"permissions": permissions,
 	})
 	return
 }
@@ -99,24 +301,102 @@ func AdminOnly() gin.HandlerFunc {
 			return
 		}
 
+		if !checkMFAPolicy(c) {
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-// Generate a JWT token for a user ID
-func generateJWT(userID string) (string, error) {
-	// Set the expiration time for the token to 1 day from now
-	expirationTime := time.Now().Add(24 * time.Hour)
+// issueTokens create a refresh token for userId and write it with a new access token as response,
+// mfa tells if the second factor was given for this session
+func issueTokens(c *gin.Context, userId string, mfa bool, message string) {
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	refreshToken, err := db.CreateRefreshToken(userIdInt, mfa, refreshTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	writeTokens(c, userId, mfa, refreshToken, message)
+}
+
+// writeTokens generate an access token for userId and write the token pair as response
+func writeTokens(c *gin.Context, userId string, mfa bool, refreshToken string, message string) {
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	// Generate a JWT token for the user ID
+	token, err := generateJWT(&Claims{
+		StandardClaims: jwt.StandardClaims{Subject: userId},
+		Version:        user.TokenVersion,
+		PasswordChange: user.MustChangePassword,
+		MFA:            mfa,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":              message,
+		"token":                token,
+		"refresh_token":        refreshToken,
+		"expires_in":           int(accessTokenTTL.Seconds()),
+		"must_change_password": user.MustChangePassword,
+	})
+}
 
-	// Create the claims for the token
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expirationTime.Unix(),
-		Subject:   userID,
+// Generate a JWT token, the token id, issue and expiration time are set here,
+// an expiration already set by the caller is kept
+func generateJWT(claims *Claims) (string, error) {
+	if signingKey == nil {
+		return "", ErrNoSigningKey
 	}
 
-	// Create the JWT token with the claims and sign it using the key
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtString, err := token.SignedString(jwtKey)
+	// token id is used as key of the revocation table
+	tokenId, err := randomTokenId()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.Id = tokenId
+	claims.IssuedAt = now.Unix()
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = now.Add(accessTokenTTL).Unix()
+	}
+
+	// Create the JWT token with the claims and sign it using the active key
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.id
+	jwtString, err := token.SignedString(signingKey.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -124,18 +404,18 @@ func generateJWT(userID string) (string, error) {
 	return jwtString, nil
 }
 
-// Verify a JWT token and return the user ID if the token is valid
-func verifyJWT(jwtString string) (string, error) {
-	// Parse the JWT token with the key
-	token, err := jwt.ParseWithClaims(jwtString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+// Verify a JWT token and return its claims if the token is valid
+func verifyJWT(jwtString string) (*Claims, error) {
+	// Parse the JWT token with the key matching its kid header
+	token, err := jwt.ParseWithClaims(jwtString, &Claims{}, lookupVerifyKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if the token is valid and has not expired
-	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
-		userID := claims.Subject
-		return userID, nil
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
 	} else {
-		return "", err
+		return nil, err
 	}
 }
@@ -0,0 +1,67 @@
+//go:build cgo
+
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAuthMiddlewarePasswordChange verifies a token waiting for a password change only reach
+// the password change routes, and is refused once an admin sets a new password
+func TestAuthMiddlewarePasswordChange(t *testing.T) {
+	if err := (JWTConfig{Algorithm: "HS256", KeyID: "hs", Secret: testSecret}).InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := db.DB
+	defer func() { db.DB = previous }()
+	conn, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(&db.User{}, &db.RefreshToken{}, &db.RevokedToken{}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Create(&db.User{ID: 1, Username: "wayan", Name: "Wayan", Password: "x", RoleID: 2, ClassID: 1, MustChangePassword: true})
+	db.DB = conn
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.PUT("/v1/me/password", AuthMiddleware(), ok)
+	router.GET("/v1/me/labs", AuthMiddleware(), ok)
+
+	token, err := generateJWT(&Claims{StandardClaims: jwt.StandardClaims{Subject: "1"}, PasswordChange: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := request(http.MethodPut, "/v1/me/password"); code != http.StatusOK {
+		t.Errorf("expected the password change to be allowed, got %d", code)
+	}
+	if code := request(http.MethodGet, "/v1/me/labs"); code != http.StatusForbidden {
+		t.Errorf("expected %d before the password change, got %d", http.StatusForbidden, code)
+	}
+
+	if err := db.PatchUsersByUsersId(1, db.User{Password: "admin-password"}); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(http.MethodPut, "/v1/me/password"); code != http.StatusUnauthorized {
+		t.Errorf("expected %d after an admin set the password, got %d", http.StatusUnauthorized, code)
+	}
+}
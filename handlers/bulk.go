@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var errBulkTooLarge = errors.New("too many records")
+
+// decodeScores read a JSON array or a NDJSON stream of score records
+func decodeScores(body io.Reader) ([]db.ScorePush, error) {
+	reader := bufio.NewReader(body)
+
+	// skip leading spaces to find if the body is an array
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\n' && b[0] != '\r' && b[0] != '\t' {
+			break
+		}
+		reader.ReadByte()
+	}
+
+	decoder := json.NewDecoder(reader)
+
+	var scores []db.ScorePush
+	if b, _ := reader.Peek(1); b[0] == '[' {
+		if err := decoder.Decode(&scores); err != nil {
+			return nil, err
+		}
+		return scores, nil
+	}
+
+	for {
+		var score db.ScorePush
+		err := decoder.Decode(&score)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(scores) == db.BulkMaxRecords {
+			return nil, errBulkTooLarge
+		}
+		scores = append(scores, score)
+	}
+
+	return scores, nil
+}
+
+// PushScoresBulk endpoint to push many scores in a single transaction, mode=partial
+// commit the valid records only and dry_run=true roll back every record
+func PushScoresBulk(c *gin.Context) {
+	options := db.BulkOptions{
+		Partial: c.Query("mode") == "partial",
+		DryRun:  c.Query("dry_run") == "true",
+	}
+	if mode := c.DefaultQuery("mode", "atomic"); mode != "atomic" && mode != "partial" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "mode must be atomic or partial",
+		})
+		return
+	}
+
+	if apiKeyId, ok := c.Get("apiKeyId"); ok {
+		id := apiKeyId.(int)
+		options.APIKeyID = &id
+	} else {
+		// users need scores:push, each record is checked on its class and course
+		if !authorize(c, db.PermScoresPush, db.Scope{}) || !checkMFAPolicy(c) {
+			return
+		}
+		userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		options.UserID = userIdInt
+	}
+
+	scores, err := decodeScores(c.Request.Body)
+	if err != nil {
+		if errors.Is(err, errBulkTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"message": "at most " + strconv.Itoa(db.BulkMaxRecords) + " records per request",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+	if len(scores) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "no score record",
+		})
+		return
+	}
+
+	ip := c.ClientIP()
+	for i := range scores {
+		scores[i].IP = ip
+		if scores[i].ClientVersion == "" {
+			scores[i].ClientVersion = c.GetHeader("X-Client-Version")
+		}
+	}
+
+	results, committed, err := db.PushScores(scores, options)
+	if err != nil {
+		if errors.Is(err, db.ErrBulkTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"message": "at most " + strconv.Itoa(db.BulkMaxRecords) + " records per request",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	status := http.StatusOK
+	message := "scores pushed"
+	switch {
+	case options.DryRun:
+		message = "dry run, nothing was saved"
+	case !committed:
+		status = http.StatusUnprocessableEntity
+		message = "a record failed, nothing was saved"
+	}
+
+	c.JSON(status, gin.H{
+		"message":     message,
+		"committed":   committed,
+		"dry_run":     options.DryRun,
+		"updated":     counts[db.BulkUpdated],
+		"not_updated": counts[db.BulkNotUpdated],
+		"failed":      counts[db.BulkFailed],
+		"results":     results,
+	})
+	return
+}
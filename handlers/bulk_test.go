@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDecodeScores verifies JSON arrays and NDJSON streams give the same records
+func TestDecodeScores(t *testing.T) {
+	array := ` [{"username":"student1","lab":"lab-1","score":60},{"username":"student2","lab":"lab-1","score":80}]`
+	ndjson := "{\"username\":\"student1\",\"lab\":\"lab-1\",\"score\":60}\n{\"username\":\"student2\",\"lab\":\"lab-1\",\"score\":80}\n"
+
+	for name, body := range map[string]string{"array": array, "ndjson": ndjson} {
+		scores, err := decodeScores(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(scores) != 2 || scores[1].Username != "student2" || scores[1].Score != 80 {
+			t.Errorf("%s: unexpected records %+v", name, scores)
+		}
+	}
+
+	if _, err := decodeScores(strings.NewReader("{\"username\":")); err == nil {
+		t.Error("expected an error for a truncated record")
+	}
+	if scores, err := decodeScores(strings.NewReader("  ")); err != nil || len(scores) != 0 {
+		t.Errorf("expected no record, got %v %v", scores, err)
+	}
+}
@@ -48,9 +48,9 @@ func CreateClass(c *gin.Context) {
 	return
 }
 
-// GetClasses is a function to get all class
+// GetClasses is a function to get all class, users other than admin only get their assigned classes
 func GetClasses(c *gin.Context) {
-	classes, err := db.GetClasses()
+	classes, err := db.GetClassesByUserId(c.GetString("userId"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal Server Error",
@@ -32,6 +32,16 @@ func CreateCourse(c *gin.Context) {
 				"message": "Course name cant be empty",
 			})
 			return
+		} else if errors.Is(err, db.ErrInvalidGradeScale) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "grade_scale must be like A:90,B:80,C:70,D:60,E:0",
+			})
+			return
+		} else if errors.Is(err, db.ErrUnsupportedPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "scoring_policy must be highest, latest, first, average or best_of_n",
+			})
+			return
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "Internal Server Error",
@@ -150,6 +160,16 @@ func UpdateCourse(c *gin.Context) {
 				"message": "Course name cant be empty",
 			})
 			return
+		case db.ErrInvalidGradeScale:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "grade_scale must be like A:90,B:80,C:70,D:60,E:0",
+			})
+			return
+		case db.ErrUnsupportedPolicy:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "scoring_policy must be highest, latest, first, average or best_of_n",
+			})
+			return
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "Internal Server Error",
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUpdateCourseInvalid verifies an invalid grade scale or scoring policy is refused with 400
+// before the course is looked up
+func TestUpdateCourseInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.PUT("/v1/admin/course", UpdateCourse)
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"name":"Linux","grade_scale":"A:ninety"}`, "grade_scale"},
+		{`{"name":"Linux","scoring_policy":"lowest"}`, "scoring_policy"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/v1/admin/course?id=1", strings.NewReader(test.body))
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), test.message) {
+			t.Errorf("%s: expected 400 about %s, got %d %s", test.body, test.message, w.Code, w.Body.String())
+		}
+	}
+}
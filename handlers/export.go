@@ -3,6 +3,7 @@ package handlers
 import (
 	"github.com/Kyuubang/shopiea/db"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,6 +14,14 @@ func ExportScore(c *gin.Context) {
 	classId := c.Query("class_id")
 	courseId := c.Query("course_id")
 
+	format, ok := exportFormat(c)
+	if !ok {
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"message": "format must be json, csv, xlsx or pdf",
+		})
+		return
+	}
+
 	courseIdInt, err := strconv.Atoi(courseId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -44,7 +53,33 @@ func ExportScore(c *gin.Context) {
 		return
 	}
 
-	report, err := structureReport(courseIdInt, classIdInt)
+	labs, report, err := structureReport(courseIdInt, classIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if format != formatJSON {
+		now := time.Now()
+		filename := exportFilename(courseName, className, now)
+		if format == formatPDF {
+			cards := reportCards{class: className, course: courseName, date: now, labs: labs, report: report}
+			err = writeReportCards(c, filename+".zip", cards)
+		} else {
+			err = writeGradebook(c, format, filename+"."+format, newGradebook(labs, report))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		return
+	}
+
+	checks, err := db.GetCheckSummary(courseIdInt, classIdInt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal Server Error",
@@ -58,79 +93,113 @@ func ExportScore(c *gin.Context) {
 		"date":    time.Now().Format("2006-01-02"),
 		"time":    time.Now().Format("15:04:05"),
 		"reports": report,
+		"checks":  checks,
 	})
 	return
 }
 
-func calculateAverageScore(scores []db.ScoreLabs) (avarage float64, total int) {
-	var sum int
+// calculateAverageScore returns the mean percentage of scores, so labs of any max score weigh the same, and the sum of points
+func calculateAverageScore(scores []db.ScoreLabs) (avarage float64, total float64) {
+	var sum float64
 	if len(scores) == 0 {
 		return 0, 0
 	}
 	for _, s := range scores {
-		sum += s.Score
+		sum += s.Percentage
+		total += s.Score
 	}
-	return float64(sum) / float64(len(scores)), sum
+	return math.Round(sum/float64(len(scores))*100) / 100, total
 }
 
-func structureReport(courseId int, classId int) (report []db.Report, err error) {
+// structureReport returns the labs of courseId and the report of each student of classId on them
+func structureReport(courseId int, classId int) (labs []db.LabInfo, report []db.Report, err error) {
 	students, err := db.GetUsersByClassId(classId)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// convert int to string
 	courseIdStr := strconv.Itoa(courseId)
 
+	categories, err := db.GetGradeCategories(courseId)
+	if err != nil {
+		return nil, nil, err
+	}
+	scale, err := db.GetGradeScale(courseId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labs, err = db.GetLabs(courseIdStr, classId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// scores of the whole class at once, the number of queries does not grow with the class
+	scores, err := db.ExportClassScores(courseId, classId)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	for _, student := range students {
+		report = append(report, studentReport(student, labs, scores[student.ID], categories, scale))
+	}
+	return labs, report, nil
+}
 
-		labs, err := db.GetLabs(courseIdStr)
-		if err != nil {
-			return nil, err
+// studentReport returns the report of student with a score for each of labs, 0 when not in scoreLabs,
+// the average and the grade of the course
+func studentReport(student db.Student, labs []db.LabInfo, scoreLabs []db.ScoreLab, categories []db.GradeCategory, scale []db.GradeStep) db.Report {
+	byLab := make(map[int]db.ScoreLab, len(scoreLabs))
+	for _, scoreLab := range scoreLabs {
+		byLab[scoreLab.LabID] = scoreLab
+	}
+
+	// a lab without score counts as 0
+	scoreLabsStruct := make([]db.ScoreLabs, len(labs))
+	for i, lab := range labs {
+		scoreLab := byLab[lab.ID]
+		scoreLabsStruct[i] = db.ScoreLabs{
+			LabName:        lab.Name,
+			Score:          scoreLab.Score,
+			Penalty:        scoreLab.Penalty,
+			OverrideAction: scoreLab.OverrideAction,
+			OverrideReason: scoreLab.OverrideReason,
+			ID:             lab.ID,
 		}
+	}
 
-		scoreLabs, err := db.ExportScores(student.ID, courseId, classId)
-		if err != nil {
-			return nil, err
+	// normalize to percentages so labs of any max score aggregate together
+	for i, lab := range labs {
+		scoreLabsStruct[i].MaxScore = lab.MaxScore
+		if lab.MaxScore > 0 {
+			scoreLabsStruct[i].Percentage = math.Round(scoreLabsStruct[i].Score/lab.MaxScore*10000) / 100
 		}
-		var scoreLabsStruct []db.ScoreLabs
-		for _, lab := range labs {
-			if len(scoreLabs) == 0 {
-				scoreLabsStruct = append(scoreLabsStruct, db.ScoreLabs{
-					LabName: lab.Name,
-					Score:   0,
-					ID:      lab.ID,
-				})
-				continue
-			}
-			var isExist bool
-			for _, scoreLab := range scoreLabs {
-				if scoreLab.LabName == lab.Name {
-					scoreLabsStruct = append(scoreLabsStruct, db.ScoreLabs{
-						LabName: lab.Name,
-						Score:   scoreLab.Score,
-						ID:      lab.ID,
-					})
-					isExist = true
-				}
-			}
-			if !isExist {
-				scoreLabsStruct = append(scoreLabsStruct, db.ScoreLabs{
-					LabName: lab.Name,
-					Score:   0,
-					ID:      lab.ID,
-				})
-			}
+	}
+
+	// weighted grade with the grading scheme of the course
+	gradedLabs := make([]db.GradedLab, len(labs))
+	for i, lab := range labs {
+		gradedLabs[i] = db.GradedLab{
+			Name:        lab.Name,
+			Category:    lab.Category,
+			Weight:      lab.Weight,
+			ExtraCredit: lab.ExtraCredit,
+			Score:       scoreLabsStruct[i].Score,
+			MaxScore:    lab.MaxScore,
 		}
+	}
+	subtotals, percentage, grade := db.ComputeGrade(gradedLabs, categories, scale)
 
-		averageScore, totalScore := calculateAverageScore(scoreLabsStruct)
-		report = append(report, db.Report{
-			Name:     student.Name,
-			Username: student.Username,
-			Average:  averageScore,
-			Scores:   scoreLabsStruct,
-			Total:    totalScore,
-		})
+	averageScore, totalScore := calculateAverageScore(scoreLabsStruct)
+	return db.Report{
+		Name:       student.Name,
+		Username:   student.Username,
+		Average:    averageScore,
+		Scores:     scoreLabsStruct,
+		Total:      totalScore,
+		Categories: subtotals,
+		Percentage: percentage,
+		Grade:      grade,
 	}
-	return report, nil
 }
@@ -0,0 +1,97 @@
+//go:build cgo
+
+// The database of these tests is the cgo SQLite driver, the other tests of the package run without cgo
+
+package handlers
+
+import (
+	"fmt"
+	"github.com/Kyuubang/shopiea/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+// seedExportDB open an in-memory database with a class of students scored on every lab of a course,
+// the returned counter is the number of queries run since
+func seedExportDB(tb testing.TB, students int, labs int) *int {
+	previous := db.DB
+	tb.Cleanup(func() { db.DB = previous })
+
+	conn, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB, _ := conn.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	err = conn.AutoMigrate(&db.Role{}, &db.Class{}, &db.User{}, &db.Course{}, &db.Lab{}, &db.LabSchedule{},
+		&db.Score{}, &db.GradeCategory{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	conn.Create(&db.Role{ID: 2, Name: db.RoleStudent})
+	conn.Create(&db.Class{ID: 1, Name: "class-a"})
+	conn.Create(&db.Course{ID: 1, Name: "course-a"})
+	for l := 1; l <= labs; l++ {
+		conn.Create(&db.Lab{ID: l, Name: fmt.Sprintf("lab-%d", l), CourseID: 1, MaxScore: 100})
+	}
+	conn.Create(&db.LabSchedule{LabID: 1, ClassID: 1})
+	for s := 1; s <= students; s++ {
+		conn.Create(&db.User{ID: s, Username: fmt.Sprintf("student%d", s), Name: "Student", Password: "x", RoleID: 2, ClassID: 1})
+		// every other lab is scored
+		for l := 1; l <= labs; l += 2 {
+			conn.Create(&db.Score{UserID: s, LabID: l, Score: float64(s % 100)})
+		}
+	}
+
+	queries := new(int)
+	count := func(*gorm.DB) { *queries++ }
+	conn.Callback().Query().Before("gorm:query").Register("test:count_query", count)
+	conn.Callback().Row().Before("gorm:row").Register("test:count_row", count)
+
+	db.DB = conn
+	return queries
+}
+
+// TestStructureReportQueryCount verifies the export runs the same number of queries for any class size
+func TestStructureReportQueryCount(t *testing.T) {
+	var counts []int
+	for _, students := range []int{5, 40} {
+		queries := seedExportDB(t, students, 30)
+
+		_, report, err := structureReport(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report) != students || len(report[0].Scores) != 30 {
+			t.Fatalf("unexpected report of %d students", len(report))
+		}
+		if report[4].Scores[0].Score != 5 || report[4].Scores[1].Score != 0 || report[4].Average != 2.5 {
+			t.Errorf("unexpected scores %+v", report[4])
+		}
+
+		counts = append(counts, *queries)
+	}
+
+	if counts[0] != counts[1] {
+		t.Errorf("query count grows with the class: %d queries for 5 students, %d for 40", counts[0], counts[1])
+	}
+}
+
+// BenchmarkStructureReport reports the queries of the export of a class of 40 students on 30 labs
+func BenchmarkStructureReport(b *testing.B) {
+	queries := seedExportDB(b, 40, 30)
+	*queries = 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := structureReport(1, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(*queries)/float64(b.N), "queries/op")
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// GetGradeCategories is a function to get the grading scheme of course_id from query
+func GetGradeCategories(c *gin.Context) {
+	courseId, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "course_id must be integer",
+		})
+		return
+	}
+
+	categories, err := db.GetGradeCategories(courseId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"categories": categories,
+	})
+	return
+}
+
+// SetGradeCategories is a function to replace the grading scheme of course_id from query
+func SetGradeCategories(c *gin.Context) {
+	courseId, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "course_id must be integer",
+		})
+		return
+	}
+
+	var categories []db.GradeCategory
+	if err := c.BindJSON(&categories); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	categories, err = db.SetGradeCategories(courseId, categories)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidCategory):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "categories need unique names, weight and drop_lowest cant be negative",
+			})
+			return
+		case errors.Is(err, db.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Course Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"categories": categories,
+		"message":    "Success set grading scheme!",
+	})
+	return
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats of ExportScore
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+	// formatPDF is a zip of PDF report cards
+	formatPDF = "pdf"
+)
+
+// exportContentTypes is the content type of each export format
+var exportContentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv",
+	formatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	formatPDF:  "application/zip",
+}
+
+// utf8BOM starts CSV exports so spreadsheets read names as UTF-8
+const utf8BOM = "\xEF\xBB\xBF"
+
+// exportFormat returns the format of the export from the format query, or else the
+// Accept header, JSON by default. false is returned when no format is acceptable
+func exportFormat(c *gin.Context) (string, bool) {
+	if format := c.Query("format"); format != "" {
+		if _, ok := exportContentTypes[format]; !ok {
+			return "", false
+		}
+		return format, true
+	}
+
+	accept := c.GetHeader("Accept")
+	if accept == "" {
+		return formatJSON, true
+	}
+
+	// media ranges from the highest quality, in order of the header when equal
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	for _, r := range ranges {
+		if r.mediaType == "*/*" || r.mediaType == "application/*" {
+			return formatJSON, true
+		}
+		for format, contentType := range exportContentTypes {
+			if r.mediaType == contentType {
+				return format, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// gradebook is the export as a table, one row per student and one column per lab
+type gradebook struct {
+	header []string
+	// rows hold string and float64 cells
+	rows [][]interface{}
+}
+
+// newGradebook returns the gradebook of report with the lab columns in the order of labs,
+// students are sorted by name
+func newGradebook(labs []db.LabInfo, report []db.Report) gradebook {
+	header := []string{"No", "Username", "Name"}
+	for _, lab := range labs {
+		header = append(header, lab.Name)
+	}
+	header = append(header, "Total", "Average", "Percentage", "Grade")
+
+	students := sortReport(report)
+
+	rows := make([][]interface{}, len(students))
+	for i, student := range students {
+		row := []interface{}{float64(i + 1), student.Username, student.Name}
+		// scores of the report are in the order of labs
+		for _, score := range student.Scores {
+			row = append(row, score.Score)
+		}
+		rows[i] = append(row, student.Total, student.Average, student.Percentage, student.Grade)
+	}
+
+	return gradebook{header: header, rows: rows}
+}
+
+// sortReport returns a copy of report with students sorted by name, then username
+func sortReport(report []db.Report) []db.Report {
+	students := make([]db.Report, len(report))
+	copy(students, report)
+	sort.SliceStable(students, func(i, j int) bool {
+		if students[i].Name != students[j].Name {
+			return students[i].Name < students[j].Name
+		}
+		return students[i].Username < students[j].Username
+	})
+
+	return students
+}
+
+// exportFilename returns a file name without extension for the export of className on courseName at date
+func exportFilename(courseName string, className string, date time.Time) string {
+	return safeFilename(courseName+"-"+className) + "-" + date.Format("2006-01-02")
+}
+
+// safeFilename replace the characters of name not allowed in file names
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// writeGradebook write book as an attachment in format
+func writeGradebook(c *gin.Context, format string, filename string, book gradebook) error {
+	var data []byte
+	var err error
+	switch format {
+	case formatCSV:
+		data, err = book.csv()
+	case formatXLSX:
+		data, err = book.xlsx()
+	}
+	if err != nil {
+		return err
+	}
+
+	contentType := exportContentTypes[format]
+	if format == formatCSV {
+		contentType += "; charset=utf-8"
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, contentType, data)
+	return nil
+}
+
+// csv returns book as UTF-8 CSV starting with a byte order mark
+func (book gradebook) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(utf8BOM)
+
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(book.header); err != nil {
+		return nil, err
+	}
+	for _, row := range book.rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			switch value := cell.(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			case string:
+				record[i] = csvText(value)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
+
+// csvText keep spreadsheets from reading value as a formula
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// xlsx returns book as a workbook with a single sheet, the header row is bold and frozen
+func (book gradebook) xlsx() ([]byte, error) {
+	file := excelize.NewFile()
+	defer file.Close()
+
+	sheet := "Gradebook"
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(book.header))
+	for i, name := range book.header {
+		header[i] = name
+	}
+	if err := file.SetSheetRow(sheet, "A1", &header); err != nil {
+		return nil, err
+	}
+	for i, row := range book.rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return nil, err
+		}
+		row := row
+		if err := file.SetSheetRow(sheet, cell, &row); err != nil {
+			return nil, err
+		}
+	}
+
+	bold, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	last, err := excelize.CoordinatesToCellName(len(book.header), 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.SetCellStyle(sheet, "A1", last, bold); err != nil {
+		return nil, err
+	}
+	err = file.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := file.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
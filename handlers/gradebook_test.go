@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestExportFormat verifies the format query takes precedence over the Accept header
+func TestExportFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query    string
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"", "", formatJSON, true},
+		{"", "*/*", formatJSON, true},
+		{"", "text/csv", formatCSV, true},
+		{"", "application/json;q=0.5, application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", formatXLSX, true},
+		{"format=csv", "application/json", formatCSV, true},
+		{"format=pdf", "text/csv", formatPDF, true},
+		{"", "application/zip", formatPDF, true},
+		{"format=html", "", "", false},
+		{"", "image/png", "", false},
+	}
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/v1/admin/export?"+test.query, nil)
+		if test.accept != "" {
+			c.Request.Header.Set("Accept", test.accept)
+		}
+
+		format, ok := exportFormat(c)
+		if format != test.expected || ok != test.ok {
+			t.Errorf("%q %q: expected %q %v, got %q %v", test.query, test.accept, test.expected, test.ok, format, ok)
+		}
+	}
+}
+
+func testGradebook() gradebook {
+	labs := []db.LabInfo{{ID: 1, Name: "lab-1"}, {ID: 2, Name: "lab-2"}}
+	report := []db.Report{
+		{Username: "wayan", Name: "I Wayan Sudirta", Scores: []db.ScoreLabs{{Score: 90}, {Score: 70.5}}, Total: 160.5, Average: 80.25, Grade: "B"},
+		{Username: "=cmd", Name: "Ayu Ñoman Pratiwi", Scores: []db.ScoreLabs{{Score: 100}, {Score: 0}}, Total: 100, Average: 50, Grade: "E"},
+	}
+
+	return newGradebook(labs, report)
+}
+
+// TestGradebookCSV verifies the columns follow the labs, names keep their UTF-8 characters
+// and cells are not read as formulas
+func TestGradebookCSV(t *testing.T) {
+	data, err := testGradebook().csv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, []byte(utf8BOM)) {
+		t.Error("expected a byte order mark")
+	}
+
+	lines := strings.Split(strings.TrimSpace(strings.TrimPrefix(string(data), utf8BOM)), "\n")
+	expected := []string{
+		"No,Username,Name,lab-1,lab-2,Total,Average,Percentage,Grade",
+		"1,'=cmd,Ayu Ñoman Pratiwi,100,0,100,50,0,E",
+		"2,wayan,I Wayan Sudirta,90,70.5,160.5,80.25,0,B",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected csv %q", data)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+// TestGradebookXLSX verifies the workbook holds the same table with numeric scores
+func TestGradebookXLSX(t *testing.T) {
+	data, err := testGradebook().xlsx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, err := file.GetRows("Gradebook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][3] != "lab-1" || rows[1][2] != "Ayu Ñoman Pratiwi" || rows[2][4] != "70.5" {
+		t.Errorf("unexpected rows %q", rows)
+	}
+
+	cellType, err := file.GetCellType("Gradebook", "D3")
+	if err != nil || cellType != excelize.CellTypeNumber && cellType != excelize.CellTypeUnset {
+		t.Errorf("expected a numeric score, got %v %v", cellType, err)
+	}
+}
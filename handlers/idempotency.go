@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader is the request header naming a retry-safe request
+const idempotencyHeader = "Idempotency-Key"
+
+// IdempotencyStore persists the first response of each Idempotency-Key, the
+// memory store is used by default and db.IdempotencyStore share it between instances
+type IdempotencyStore interface {
+	// Reserve take the key of record, or returns the record already holding it
+	Reserve(record db.IdempotencyRecord) (db.IdempotencyRecord, bool, error)
+	Complete(record db.IdempotencyRecord) error
+	Release(key string) error
+	Purge(before time.Time) error
+}
+
+// IdempotencyConfig is a struct to store idempotency keys configuration
+type IdempotencyConfig struct {
+	// TTL is how long a response is replayed, default 24h
+	TTL time.Duration
+	// Store is memory or database
+	Store string
+	// MaxBody is the size limit in bytes of a request body with an Idempotency-Key, default 8 MiB
+	// to fit an attempt artifact
+	MaxBody int64
+}
+
+var defaultIdempotencyConfig = IdempotencyConfig{
+	TTL:     24 * time.Hour,
+	Store:   "memory",
+	MaxBody: 8 << 20,
+}
+
+var idempotencyConfig = defaultIdempotencyConfig
+
+var idempotencyStore IdempotencyStore = newMemoryIdempotencyStore()
+
+// InitIdempotency set the idempotency keys configuration, zero values keep the default
+func (config IdempotencyConfig) InitIdempotency() {
+	if config.TTL <= 0 {
+		config.TTL = defaultIdempotencyConfig.TTL
+	}
+	if config.MaxBody <= 0 {
+		config.MaxBody = defaultIdempotencyConfig.MaxBody
+	}
+
+	switch config.Store {
+	case "database":
+		idempotencyStore = db.IdempotencyStore{}
+	default:
+		config.Store = "memory"
+		idempotencyStore = newMemoryIdempotencyStore()
+	}
+
+	idempotencyConfig = config
+}
+
+// idempotencyWriter keep a copy of the response body
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware replay the first response of a mutating request sent again
+// with the same Idempotency-Key, keys are scoped to the user or api key
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		// the body is kept in memory to be hashed and read again by the handler
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, idempotencyConfig.MaxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		owner := "user:" + c.GetString("userId")
+		if apiKeyId, ok := c.Get("apiKeyId"); ok {
+			owner = "apikey:" + strconv.Itoa(apiKeyId.(int))
+		}
+
+		request := sha256.New()
+		request.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+		request.Write(requestContent(c.GetHeader("Content-Type"), body))
+
+		now := time.Now()
+		record := db.IdempotencyRecord{
+			Key:         owner + ":" + key,
+			RequestHash: hex.EncodeToString(request.Sum(nil)),
+			ExpiresAt:   now.Add(idempotencyConfig.TTL),
+		}
+
+		existing, found, err := idempotencyStore.Reserve(record)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if found {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Idempotency-Key already used with another request"})
+			case existing.Status == 0:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.Status, existing.ContentType, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// the key is released unless the response is kept, e.g. when the handler panics,
+		// so the request can be retried
+		completed := false
+		defer func() {
+			if !completed {
+				_ = idempotencyStore.Release(record.Key)
+			}
+		}()
+
+		c.Next()
+
+		// server errors are not kept so the request can be retried
+		if writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Status = writer.Status()
+		record.ContentType = writer.Header().Get("Content-Type")
+		record.Body = writer.body.Bytes()
+		if err := idempotencyStore.Complete(record); err != nil {
+			return
+		}
+		completed = true
+
+		_ = idempotencyStore.Purge(now)
+	}
+}
+
+// requestContent returns what identifies body in the request hash. Multipart bodies are separated by a
+// boundary clients generate for each request, so a retry is identified by the name and SHA-256 of each
+// part, sorted by name, and not by the raw body
+func requestContent(contentType string, body []byte) []byte {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return body
+	}
+
+	var parts []string
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the handler refuses the body
+			return body
+		}
+
+		digest := sha256.New()
+		if _, err := io.Copy(digest, part); err != nil {
+			return body
+		}
+		parts = append(parts, part.FormName()+" "+part.FileName()+" "+hex.EncodeToString(digest.Sum(nil)))
+		part.Close()
+	}
+	sort.Strings(parts)
+
+	return []byte(strings.Join(parts, "\n"))
+}
+
+// memoryIdempotencyStore is an IdempotencyStore local to the process
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]db.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]db.IdempotencyRecord{}}
+}
+
+func (store *memoryIdempotencyStore) Reserve(record db.IdempotencyRecord) (db.IdempotencyRecord, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if existing, ok := store.records[record.Key]; ok && existing.ExpiresAt.After(time.Now()) {
+		return existing, true, nil
+	}
+
+	store.records[record.Key] = record
+	return db.IdempotencyRecord{}, false, nil
+}
+
+func (store *memoryIdempotencyStore) Complete(record db.IdempotencyRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.records[record.Key] = record
+	return nil
+}
+
+func (store *memoryIdempotencyStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.records, key)
+	return nil
+}
+
+func (store *memoryIdempotencyStore) Purge(before time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, record := range store.records {
+		if record.ExpiresAt.Before(before) {
+			delete(store.records, key)
+		}
+	}
+	return nil
+}
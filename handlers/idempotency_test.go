@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestIdempotencyMiddleware verifies a retry is replayed and another payload with the same key is refused
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	IdempotencyConfig{}.InitIdempotency()
+
+	var calls int
+	router := gin.New()
+	router.POST("/score", func(c *gin.Context) {
+		c.Set("userId", "1")
+		c.Next()
+	}, IdempotencyMiddleware(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"message": "score updated"})
+	})
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/score", strings.NewReader(body))
+		req.Header.Set(idempotencyHeader, key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("k1", `{"score":60}`)
+	retry := send("k1", `{"score":60}`)
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response replayed, got %d %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header")
+	}
+
+	if conflict := send("k1", `{"score":100}`); conflict.Code != http.StatusConflict {
+		t.Errorf("expected 409 for another payload, got %d", conflict.Code)
+	}
+
+	send("k2", `{"score":100}`)
+	if calls != 2 {
+		t.Errorf("expected a new key to run the handler, ran %d times", calls)
+	}
+}
+
+// TestIdempotencyMaxBody verifies a body over the limit is refused before the handler runs
+func TestIdempotencyMaxBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	IdempotencyConfig{MaxBody: 16}.InitIdempotency()
+	defer IdempotencyConfig{}.InitIdempotency()
+
+	var calls int
+	router := gin.New()
+	router.POST("/score", IdempotencyMiddleware(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"message": "score updated"})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/score", strings.NewReader(`{"score":60,"lab":"lab-1"}`))
+	req.Header.Set(idempotencyHeader, "k1")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("expected 413 without running the handler, got %d and %d calls", w.Code, calls)
+	}
+}
+
+// TestIdempotencyMultipart verifies a multipart push retried with another boundary is replayed,
+// and another artifact with the same key is refused
+func TestIdempotencyMultipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	IdempotencyConfig{}.InitIdempotency()
+
+	var calls int
+	router := gin.New()
+	router.POST("/score", func(c *gin.Context) {
+		c.Set("userId", "1")
+		c.Next()
+	}, IdempotencyMiddleware(), func(c *gin.Context) {
+		if _, _, err := bindScorePush(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Bad Request"})
+			return
+		}
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"message": "score updated"})
+	})
+
+	send := func(key, artifact string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("score", `{"username":"student","lab":"lab-1","score":80}`)
+		file, _ := writer.CreateFormFile("artifact", "grader.log")
+		_, _ = file.Write([]byte(artifact))
+		_ = writer.Close()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/score", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(idempotencyHeader, key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("k1", "all checks ok")
+	retry := send("k1", "all checks ok")
+	if first.Code != http.StatusCreated || calls != 1 {
+		t.Fatalf("expected the handler to run once, got %d and %d calls", first.Code, calls)
+	}
+	if retry.Code != first.Code || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the first response replayed, got %d %s", retry.Code, retry.Body.String())
+	}
+
+	if conflict := send("k1", "check 2 failed"); conflict.Code != http.StatusConflict {
+		t.Errorf("expected 409 for another artifact, got %d", conflict.Code)
+	}
+}
+
+// TestIdempotencyRetryAfterFailure verifies the key is released when the handler panics or
+// answers a server error, so a retry runs the handler again
+func TestIdempotencyRetryAfterFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	IdempotencyConfig{}.InitIdempotency()
+
+	var calls int
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	router.POST("/score", IdempotencyMiddleware(), func(c *gin.Context) {
+		calls++
+		switch calls {
+		case 1:
+			panic("database is gone")
+		case 2:
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal Server Error"})
+		default:
+			c.JSON(http.StatusCreated, gin.H{"message": "score updated"})
+		}
+	})
+
+	send := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/score", strings.NewReader(`{"score":60}`))
+		req.Header.Set(idempotencyHeader, "k1")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	expected := []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusCreated}
+	for i, code := range expected {
+		if got := send(); got != code {
+			t.Errorf("attempt %d: expected %d, got %d", i+1, code, got)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected every failed attempt to be retried, ran %d times", calls)
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSigningKey     = errors.New("jwt signing key is not configured")
+	ErrUnsupportedAlg   = errors.New("unsupported jwt algorithm")
+	ErrUnexpectedAlg    = errors.New("unexpected jwt signing algorithm")
+	ErrUnknownKeyId     = errors.New("unknown jwt key id")
+	ErrWeakSigningKey   = errors.New("jwt secret must be at least 32 bytes")
+	ErrInvalidVerifyKey = errors.New("invalid jwt verification key entry")
+)
+
+// JWTConfig is a struct to store token signing configuration
+type JWTConfig struct {
+	// Algorithm is one of HS256, HS384, HS512, RS256 or ES256
+	Algorithm string
+	// KeyID is written to the kid header of every issued token
+	KeyID string
+	// Secret is the HMAC secret, SecretFile is read when Secret is empty
+	Secret     string
+	SecretFile string
+	// PrivateKeyFile is a PEM encoded RSA or EC private key
+	PrivateKeyFile string
+	// VerifyKeys is a comma separated list of kid:alg:path entries that are
+	// still accepted for verification, e.g. while rotating to a new key
+	VerifyKeys string
+	// AccessTTL and RefreshTTL are the lifetime of issued tokens
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Claims is the payload of an access token
+type Claims struct {
+	jwt.StandardClaims
+	// Version must match the user token version, see db.RevokeUserSessions
+	Version int `json:"ver"`
+	// PasswordChange restrict the token to the password change endpoint
+	PasswordChange bool `json:"pwd_change,omitempty"`
+	// MFA is set when the login completed the second factor
+	MFA bool `json:"mfa,omitempty"`
+	// Scope mfa_pending is only accepted by /auth/login/mfa
+	Scope string `json:"scope,omitempty"`
+}
+
+// jwtKey holds the material for a single kid
+type jwtKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+var (
+	// signingKey is the key used for new tokens
+	signingKey *jwtKey
+	// verifyKeys contains every key accepted by verifyJWT, indexed by kid
+	verifyKeys = map[string]*jwtKey{}
+	// accessTokenTTL is kept short, clients renew it with the refresh token
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// InitJWT load the signing and verification keys from configuration
+func (config JWTConfig) InitJWT() error {
+	if config.Algorithm == "" {
+		config.Algorithm = jwt.SigningMethodHS256.Alg()
+	}
+	if config.KeyID == "" {
+		config.KeyID = "default"
+	}
+
+	key, err := loadSigningKey(config)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*jwtKey{key.id: key}
+
+	for _, entry := range strings.Split(config.VerifyKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// split kid:alg:path, the path itself may contain colons
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("%w: %s", ErrInvalidVerifyKey, entry)
+		}
+
+		verifyKey, err := loadVerifyKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return err
+		}
+		keys[verifyKey.id] = verifyKey
+	}
+
+	signingKey = key
+	verifyKeys = keys
+
+	if config.AccessTTL > 0 {
+		accessTokenTTL = config.AccessTTL
+	}
+	if config.RefreshTTL > 0 {
+		refreshTokenTTL = config.RefreshTTL
+	}
+
+	return nil
+}
+
+// loadSigningKey build the active key from secret or private key file
+func loadSigningKey(config JWTConfig) (*jwtKey, error) {
+	method := jwt.GetSigningMethod(config.Algorithm)
+
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret := []byte(config.Secret)
+		if config.Secret == "" && config.SecretFile != "" {
+			data, err := os.ReadFile(config.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		}
+		if len(secret) == 0 {
+			return nil, ErrNoSigningKey
+		}
+		if len(secret) < 32 {
+			return nil, ErrWeakSigningKey
+		}
+
+		return &jwtKey{id: config.KeyID, method: method, signKey: secret, verifyKey: secret}, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		if config.PrivateKeyFile == "" {
+			return nil, ErrNoSigningKey
+		}
+		data, err := os.ReadFile(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := method.(*jwt.SigningMethodRSA); ok {
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			return &jwtKey{id: config.KeyID, method: method, signKey: privateKey, verifyKey: &privateKey.PublicKey}, nil
+		}
+
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &jwtKey{id: config.KeyID, method: method, signKey: privateKey, verifyKey: &privateKey.PublicKey}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, config.Algorithm)
+	}
+}
+
+// loadVerifyKey read a verification only key, a secret for HS* or a public key for RS256/ES256
+func loadVerifyKey(kid, alg, path string) (*jwtKey, error) {
+	method := jwt.GetSigningMethod(alg)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < 32 {
+			return nil, ErrWeakSigningKey
+		}
+		return &jwtKey{id: kid, method: method, verifyKey: secret}, nil
+	case *jwt.SigningMethodRSA:
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &jwtKey{id: kid, method: method, verifyKey: publicKey}, nil
+	case *jwt.SigningMethodECDSA:
+		publicKey, err := jwt.ParseECPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &jwtKey{id: kid, method: method, verifyKey: publicKey}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+}
+
+// lookupVerifyKey is the jwt.Keyfunc that select the key by kid and
+// refuse tokens whose alg header does not match the key
+func lookupVerifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := verifyKeys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyId
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, ErrUnexpectedAlg
+	}
+
+	return key.verifyKey, nil
+}
+
+// randomTokenId generate the jti claim of an access token
+func randomTokenId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
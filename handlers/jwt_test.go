@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeRSAKey generate a RSA key pair and store it as PEM files
+func writeRSAKey(t *testing.T, dir string) (privatePath string, publicPath string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath = filepath.Join(dir, "private.pem")
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(privatePath, privatePem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPath = filepath.Join(dir, "public.pem")
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})
+	if err := os.WriteFile(publicPath, publicPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+// TestJWTRoundTrip verifies tokens signed with the active key are accepted
+func TestJWTRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	privatePath, _ := writeRSAKey(t, dir)
+
+	configs := []JWTConfig{
+		{Algorithm: "HS256", KeyID: "hs", Secret: testSecret},
+		{Algorithm: "HS512", KeyID: "hs512", Secret: testSecret},
+		{Algorithm: "RS256", KeyID: "rs", PrivateKeyFile: privatePath},
+	}
+
+	for _, config := range configs {
+		if err := config.InitJWT(); err != nil {
+			t.Fatalf("%s: %v", config.Algorithm, err)
+		}
+
+		token, err := generateJWT(&Claims{StandardClaims: jwt.StandardClaims{Subject: "42"}})
+		if err != nil {
+			t.Fatalf("%s: %v", config.Algorithm, err)
+		}
+
+		claims, err := verifyJWT(token)
+		if err != nil {
+			t.Fatalf("%s: %v", config.Algorithm, err)
+		}
+		if claims.Subject != "42" {
+			t.Errorf("%s: expected subject 42, got %s", config.Algorithm, claims.Subject)
+		}
+		if claims.Id == "" {
+			t.Errorf("%s: expected token id to be set", config.Algorithm)
+		}
+	}
+}
+
+// TestJWTRejectsUnexpectedAlg verifies a HS256 token forged with the RSA public key is refused
+func TestJWTRejectsUnexpectedAlg(t *testing.T) {
+	dir := t.TempDir()
+	privatePath, publicPath := writeRSAKey(t, dir)
+
+	config := JWTConfig{Algorithm: "RS256", KeyID: "rs", PrivateKeyFile: privatePath}
+	if err := config.InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+
+	publicPem, err := os.ReadFile(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// classic algorithm confusion: sign with HS256 using the public key as secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Subject: "1"})
+	forged.Header["kid"] = "rs"
+	forgedString, err := forged.SignedString(publicPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifyJWT(forgedString); err == nil {
+		t.Error("token signed with unexpected algorithm should be rejected")
+	}
+}
+
+// TestJWTKeyRotation verifies tokens of a retired key are still accepted through VerifyKeys
+func TestJWTKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	oldConfig := JWTConfig{Algorithm: "HS256", KeyID: "old", Secret: testSecret}
+	if err := oldConfig.InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := generateJWT(&Claims{StandardClaims: jwt.StandardClaims{Subject: "7"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretPath := filepath.Join(dir, "old.secret")
+	if err := os.WriteFile(secretPath, []byte(testSecret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath, _ := writeRSAKey(t, dir)
+	newConfig := JWTConfig{
+		Algorithm:      "RS256",
+		KeyID:          "new",
+		PrivateKeyFile: privatePath,
+		VerifyKeys:     "old:HS256:" + secretPath,
+	}
+	if err := newConfig.InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifyJWT(oldToken); err != nil {
+		t.Errorf("token of rotated key should be accepted: %v", err)
+	}
+
+	// without the old key in VerifyKeys the token must be refused
+	newConfig.VerifyKeys = ""
+	if err := newConfig.InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyJWT(oldToken); err == nil {
+		t.Error("token of removed key should be rejected")
+	}
+}
+
+// TestJWTWeakSecret verifies short HMAC secrets are refused
+func TestJWTWeakSecret(t *testing.T) {
+	config := JWTConfig{Algorithm: "HS256", Secret: "my_secret_key"}
+	if err := config.InitJWT(); err != ErrWeakSigningKey {
+		t.Errorf("expected ErrWeakSigningKey, got %v", err)
+	}
+}
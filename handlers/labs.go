@@ -19,6 +19,11 @@ func CreateLabs(c *gin.Context) {
 		return
 	}
 
+	// check permission on the course of the new lab
+	if !authorize(c, db.PermLabsWrite, db.Scope{CourseID: labs.CourseID}) {
+		return
+	}
+
 	res, err := db.CreateLab(labs)
 	if err != nil {
 		if errors.Is(err, db.ErrAlreadyExist) {
@@ -31,6 +36,26 @@ func CreateLabs(c *gin.Context) {
 				"message": "Lab name cant be empty",
 			})
 			return
+		} else if errors.Is(err, db.ErrInvalidSchedule) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "opens_at, due_at and closes_at must be in order, penalties between 0 and 100",
+			})
+			return
+		} else if errors.Is(err, db.ErrScoreInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "max_score cant be negative",
+			})
+			return
+		} else if errors.Is(err, db.ErrInvalidWeight) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "weight cant be negative",
+			})
+			return
+		} else if errors.Is(err, db.ErrUnsupportedPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "scoring_policy must be highest, latest, first, average or best_of_n",
+			})
+			return
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "Internal Server Error",
@@ -51,7 +76,24 @@ func CreateLabs(c *gin.Context) {
 
 // GetLabs is a function to get all labs based on course name
 func GetLabs(c *gin.Context) {
-	labs, err := db.GetLabs(c.Query("course_id"))
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	// deadlines are shown for the class of the user
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	labs, err := db.GetLabs(c.Query("course_id"), user.ClassID)
 	if err != nil {
 		if errors.Is(err, db.ErrCantBeEmpty) {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -117,6 +159,11 @@ func UpdateLabs(c *gin.Context) {
 		return
 	}
 
+	// moving the lab to another course needs permission on that course too
+	if labs.CourseID != 0 && !authorize(c, db.PermLabsWrite, db.Scope{CourseID: labs.CourseID}) {
+		return
+	}
+
 	err = db.UpdateLabByLabId(labsIdInt, labs)
 	if err != nil {
 		switch err {
@@ -125,6 +172,26 @@ func UpdateLabs(c *gin.Context) {
 				"message": "Lab name cant be empty",
 			})
 			return
+		case db.ErrInvalidSchedule:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "opens_at, due_at and closes_at must be in order, penalties between 0 and 100",
+			})
+			return
+		case db.ErrScoreInvalid:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "max_score cant be negative",
+			})
+			return
+		case db.ErrInvalidWeight:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "weight cant be negative",
+			})
+			return
+		case db.ErrUnsupportedPolicy:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "scoring_policy must be highest, latest, first, average or best_of_n",
+			})
+			return
 		case db.ErrNotFound:
 			c.JSON(http.StatusNotFound, gin.H{
 				"message": "Labs Not Found",
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// ChangePassword endpoint for user to change own password, returns a new token
+// pair because every other session is revoked
+func ChangePassword(c *gin.Context) {
+	var change db.PasswordChange
+	if err := c.BindJSON(&change); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	userId := c.MustGet("userId").(string)
+
+	// convert userId to int
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	err = db.ChangePassword(userIdInt, change)
+	if err != nil {
+		switch err {
+		case db.ErrCantBeEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "old_password, new_password cant be empty",
+			})
+			return
+		case db.ErrPasswordTooShort:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "new_password must be at least 8 characters",
+			})
+			return
+		case db.ErrExternalAccount:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Password is managed by the external login provider",
+			})
+			return
+		case db.ErrInvalidPassword:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Invalid old password",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	issueTokens(c, userId, c.GetBool("mfa"), "Success change password!")
+	return
+}
+
+// GetProgress endpoint for user to get own score, attempts, status and deadlines of every lab
+// of course_id from query, with the course average and grade of ExportScore
+func GetProgress(c *gin.Context) {
+	courseIdInt, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "course_id must be integer",
+		})
+		return
+	}
+
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	courseName, err := db.GetCourseNameById(courseIdInt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Course Not Found",
+		})
+		return
+	}
+
+	labs, err := db.GetLabs(strconv.Itoa(courseIdInt), user.ClassID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	categories, err := db.GetGradeCategories(courseIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	scale, err := db.GetGradeScale(courseIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	scoreLabs, err := db.ExportScores(user.ID, courseIdInt, user.ClassID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	counts, err := db.GetAttemptCounts(user.ID, courseIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	student := db.Student{ID: user.ID, Username: user.Username, Name: user.Name}
+	report := studentReport(student, labs, scoreLabs, categories, scale)
+
+	c.JSON(http.StatusOK, gin.H{
+		"course":     courseName,
+		"username":   user.Username,
+		"labs":       labProgress(labs, report, scoreLabs, counts),
+		"average":    report.Average,
+		"total":      report.Total,
+		"percentage": report.Percentage,
+		"grade":      report.Grade,
+		"categories": report.Categories,
+	})
+	return
+}
+
+// labProgress returns the progress on each of labs from the report of the student, a lab
+// without score nor attempt is not started and a scored lab is passed from the pass percentage
+func labProgress(labs []db.LabInfo, report db.Report, scoreLabs []db.ScoreLab, attempts map[int]int) []db.LabProgress {
+	scored := map[int]bool{}
+	for _, scoreLab := range scoreLabs {
+		scored[scoreLab.LabID] = true
+	}
+
+	progress := make([]db.LabProgress, len(labs))
+	for i, lab := range labs {
+		score := report.Scores[i]
+
+		status := db.ProgressNotStarted
+		if scored[lab.ID] || attempts[lab.ID] > 0 {
+			status = db.ProgressInProgress
+			if db.LabPassed(score.Percentage) {
+				status = db.ProgressPassed
+			}
+		}
+
+		progress[i] = db.LabProgress{
+			ID:         lab.ID,
+			Lab:        lab.Name,
+			Category:   lab.Category,
+			Score:      score.Score,
+			MaxScore:   lab.MaxScore,
+			Percentage: score.Percentage,
+			Penalty:    score.Penalty,
+			Attempts:   attempts[lab.ID],
+			Status:     status,
+			OpensAt:    lab.OpensAt,
+			DueAt:      lab.DueAt,
+			ClosesAt:   lab.ClosesAt,
+		}
+	}
+
+	return progress
+}
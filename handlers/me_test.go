@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"testing"
+)
+
+// TestLabProgress verifies the status of labs from their score and attempts
+func TestLabProgress(t *testing.T) {
+	labs := []db.LabInfo{
+		{ID: 1, Name: "lab-1", MaxScore: 100, Weight: 1},
+		{ID: 2, Name: "lab-2", MaxScore: 10, Weight: 1},
+		{ID: 3, Name: "lab-3", MaxScore: 100, Weight: 1},
+		{ID: 4, Name: "lab-4", MaxScore: 100, Weight: 1},
+	}
+	scoreLabs := []db.ScoreLab{
+		{LabID: 1, LabName: "lab-1", Score: 100},
+		{LabID: 2, LabName: "lab-2", Score: 6},
+		{LabID: 4, LabName: "lab-4", Score: 0},
+	}
+	attempts := map[int]int{1: 2, 2: 3}
+
+	report := studentReport(db.Student{Username: "student1"}, labs, scoreLabs, nil, nil)
+	progress := labProgress(labs, report, scoreLabs, attempts)
+
+	expected := []struct {
+		status     string
+		percentage float64
+		attempts   int
+	}{
+		{db.ProgressPassed, 100, 2},
+		{db.ProgressInProgress, 60, 3},
+		{db.ProgressNotStarted, 0, 0},
+		// a score set by an override without attempt
+		{db.ProgressInProgress, 0, 0},
+	}
+	for i, lab := range progress {
+		if lab.Status != expected[i].status || lab.Percentage != expected[i].percentage || lab.Attempts != expected[i].attempts {
+			t.Errorf("%s: unexpected %+v", lab.Lab, lab)
+		}
+	}
+
+	if report.Average != 40 {
+		t.Errorf("expected average 40, got %v", report.Average)
+	}
+}
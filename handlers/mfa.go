@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mfaPendingScope is the scope of the token returned by /auth/login when a TOTP code is needed
+const mfaPendingScope = "mfa_pending"
+
+// MFAConfig is a struct to store two-factor authentication configuration
+type MFAConfig struct {
+	// RequiredRoles is a comma separated list of roles refused by AdminOnly and
+	// RequirePermission until they login with a second factor
+	RequiredRoles string
+	// Issuer is shown by authenticator apps, default Shopiea
+	Issuer string
+	// PendingTTL is how long the second step of the login can be completed
+	PendingTTL time.Duration
+}
+
+var mfaConfig = MFAConfig{
+	Issuer:     "Shopiea",
+	PendingTTL: 5 * time.Minute,
+}
+
+// mfaRequiredRoles is the parsed RequiredRoles
+var mfaRequiredRoles = map[string]bool{}
+
+// InitMFA set the two-factor configuration, zero values keep the default
+func (config MFAConfig) InitMFA() {
+	if config.Issuer == "" {
+		config.Issuer = mfaConfig.Issuer
+	}
+	if config.PendingTTL == 0 {
+		config.PendingTTL = mfaConfig.PendingTTL
+	}
+
+	roles := map[string]bool{}
+	for _, role := range strings.Split(config.RequiredRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles[role] = true
+		}
+	}
+
+	mfaConfig = config
+	mfaRequiredRoles = roles
+}
+
+// checkMFAPolicy refuse the request when the user role requires a second factor
+// and the token did not complete it, the error response is written here
+func checkMFAPolicy(c *gin.Context) bool {
+	if len(mfaRequiredRoles) == 0 || c.GetBool("mfa") {
+		return true
+	}
+
+	userIdInt, err := strconv.Atoi(c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized!",
+		})
+		return false
+	}
+
+	role, err := db.GetUserRole(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User Not Found",
+		})
+		return false
+	}
+
+	if mfaRequiredRoles[role.Name] {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Two-factor authentication required, enroll on /v1/me/mfa/totp and login again",
+		})
+		return false
+	}
+
+	return true
+}
+
+// completeLogin issue the tokens of userId after the first factor, users with
+// TOTP enabled receive a short-lived mfa token for /auth/login/mfa instead
+func completeLogin(c *gin.Context, userId string, message string) {
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	enabled, err := db.IsTOTPEnabled(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if !enabled {
+		issueTokens(c, userId, false, message)
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	token, err := generateJWT(&Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userId,
+			ExpiresAt: time.Now().Add(mfaConfig.PendingTTL).Unix(),
+		},
+		Version: user.TokenVersion,
+		Scope:   mfaPendingScope,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Two-factor code required",
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(mfaConfig.PendingTTL.Seconds()),
+	})
+}
+
+// LoginMFA is the second step of the login, it exchange the mfa token and a
+// TOTP or recovery code for the token pair
+func LoginMFA(c *gin.Context) {
+	var login db.MFALogin
+	if err := c.BindJSON(&login); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	// codes are brute-forced like passwords, share the same protection
+	ip := c.ClientIP()
+	blockedUntil, err := ipBlockedUntil(ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !blockedUntil.IsZero() {
+		c.Header("Retry-After", retryAfter(blockedUntil))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many login attempts, try again later",
+		})
+		return
+	}
+
+	claims, err := verifyJWT(login.MFAToken)
+	if err != nil || claims.Scope != mfaPendingScope {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid mfa_token",
+		})
+		return
+	}
+
+	userIdInt, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid mfa_token",
+		})
+		return
+	}
+
+	revoked, err := db.IsTokenRevoked(userIdInt, claims.Id, claims.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid mfa_token",
+		})
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	lockedUntil, err := userLockedUntil(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !lockedUntil.IsZero() {
+		c.Header("Retry-After", retryAfter(lockedUntil))
+		c.JSON(http.StatusLocked, gin.H{
+			"message": "Account is locked, try again later",
+		})
+		return
+	}
+
+	err = db.VerifyMFA(userIdInt, login.Code)
+	if err != nil {
+		if !errors.Is(err, db.ErrInvalidMFACode) && !errors.Is(err, db.ErrTOTPNotEnabled) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		if err := recordIPFailure(ip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		if err := recordUserFailure(user.Username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	// the mfa token can only be used once
+	err = db.RevokeAccessToken(userIdInt, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if err := resetFailures(ipKey(ip), userKey(user.Username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	issueTokens(c, claims.Subject, true, "Success login!")
+	return
+}
+
+// EnrollTOTP is a function to generate a TOTP secret for the current user,
+// the otpauth uri is meant to be shown as QR code
+func EnrollTOTP(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	secret, uri, err := db.BeginTOTPEnrollment(userIdInt, mfaConfig.Issuer)
+	if err != nil {
+		switch err {
+		case db.ErrTOTPAlreadyEnabled:
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "TOTP already enabled",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":  secret,
+		"uri":     uri,
+		"message": "Scan the uri and confirm with a code on /v1/me/mfa/totp/verify",
+	})
+	return
+}
+
+// VerifyTOTP is a function to enable TOTP with a first code, the recovery codes are only shown here
+func VerifyTOTP(c *gin.Context) {
+	var code db.MFACode
+	if err := c.BindJSON(&code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	recoveryCodes, err := db.ConfirmTOTPEnrollment(userIdInt, code.Code)
+	if err != nil {
+		switch err {
+		case db.ErrTOTPAlreadyEnabled:
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "TOTP already enabled",
+			})
+			return
+		case db.ErrTOTPNotEnrolled:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Start the enrollment on /v1/me/mfa/totp first",
+			})
+			return
+		case db.ErrInvalidMFACode:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid code",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recovery_codes": recoveryCodes,
+		"message":        "Success enable TOTP, login again to use it",
+	})
+	return
+}
+
+// DisableTOTP is a function to disable TOTP of the current user with a TOTP or recovery code
+func DisableTOTP(c *gin.Context) {
+	var code db.MFACode
+	if err := c.BindJSON(&code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	err = db.DisableTOTP(userIdInt, code.Code)
+	if err != nil {
+		switch err {
+		case db.ErrTOTPNotEnabled:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "TOTP not enabled",
+			})
+			return
+		case db.ErrInvalidMFACode:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid code",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success disable TOTP",
+	})
+	return
+}
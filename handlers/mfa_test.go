@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// TestMFAPendingTokenRefused verifies a token waiting for the second factor is not accepted as access token
+func TestMFAPendingTokenRefused(t *testing.T) {
+	if err := (JWTConfig{Algorithm: "HS256", KeyID: "hs", Secret: testSecret}).InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := generateJWT(&Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "1",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		Scope: mfaPendingScope,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/v1/auth/check", AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/v1/auth/check", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", recorder.Code)
+	}
+}
+
+// TestMFARequiredRoles verifies the parsing of the role policy
+func TestMFARequiredRoles(t *testing.T) {
+	defer func() { MFAConfig{}.InitMFA() }()
+
+	MFAConfig{RequiredRoles: "admin, instructor"}.InitMFA()
+
+	if !mfaRequiredRoles["admin"] || !mfaRequiredRoles["instructor"] || mfaRequiredRoles["student"] {
+		t.Errorf("unexpected required roles %v", mfaRequiredRoles)
+	}
+	if mfaConfig.Issuer != "Shopiea" || mfaConfig.PendingTTL != 5*time.Minute {
+		t.Errorf("zero values should keep the default, got %+v", mfaConfig)
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oidc cookies keep state and nonce between the redirect and the callback
+const (
+	oidcStateCookie = "shopiea_oidc_state"
+	oidcNonceCookie = "shopiea_oidc_nonce"
+	oidcCookieTTL   = 10 * time.Minute
+)
+
+var (
+	ErrOIDCDisabled     = errors.New("oidc login is not configured")
+	ErrOIDCInvalidNonce = errors.New("oidc nonce mismatch")
+)
+
+// OIDCConfig is a struct to store OpenID Connect login configuration
+type OIDCConfig struct {
+	// Issuer URL used for discovery, oidc login is disabled when empty
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL must point to /auth/oidc/callback
+	RedirectURL string
+	// Scopes requested besides openid, comma separated, default profile,email
+	Scopes string
+	// claims mapped to the user, default preferred_username, name and groups
+	UsernameClaim string
+	NameClaim     string
+	GroupsClaim   string
+}
+
+// oidcClient is the configured provider, nil when oidc login is disabled
+var oidcClient *oidcLogin
+
+type oidcLogin struct {
+	config   OIDCConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// InitOIDC discover the provider of Issuer, it does nothing when Issuer is empty
+func (config OIDCConfig) InitOIDC(ctx context.Context) error {
+	if config.Issuer == "" {
+		oidcClient = nil
+		return nil
+	}
+
+	client, err := newOIDCLogin(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	oidcClient = client
+	return nil
+}
+
+func newOIDCLogin(ctx context.Context, config OIDCConfig) (*oidcLogin, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc login needs client id and redirect url")
+	}
+
+	if config.Scopes == "" {
+		config.Scopes = "profile,email"
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "name"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range strings.Split(config.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &oidcLogin{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// identity exchange code for an id token and map its claims, nonce must match the one sent on redirect
+func (login *oidcLogin) identity(ctx context.Context, code string, nonce string) (db.Identity, error) {
+	token, err := login.oauth2.Exchange(ctx, code)
+	if err != nil {
+		return db.Identity{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return db.Identity{}, errors.New("oidc token response without id_token")
+	}
+
+	idToken, err := login.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return db.Identity{}, err
+	}
+	if nonce == "" || idToken.Nonce != nonce {
+		return db.Identity{}, ErrOIDCInvalidNonce
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return db.Identity{}, err
+	}
+
+	identity := db.Identity{}
+	identity.Username, _ = claims[login.config.UsernameClaim].(string)
+	identity.Name, _ = claims[login.config.NameClaim].(string)
+	if identity.Username == "" {
+		return db.Identity{}, errors.New("oidc id token without " + login.config.UsernameClaim)
+	}
+
+	groups, _ := claims[login.config.GroupsClaim].([]interface{})
+	for _, group := range groups {
+		if name, ok := group.(string); ok {
+			// keycloak send group paths like /students
+			identity.Groups = append(identity.Groups, strings.TrimPrefix(name, "/"))
+		}
+	}
+
+	return identity, nil
+}
+
+// OIDCLogin redirect to the provider authorization endpoint
+func OIDCLogin(c *gin.Context) {
+	if oidcClient == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": ErrOIDCDisabled.Error(),
+		})
+		return
+	}
+
+	state, err := randomTokenId()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	nonce, err := randomTokenId()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	maxAge := int(oidcCookieTTL.Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.SetCookie(oidcNonceCookie, nonce, maxAge, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, oidcClient.oauth2.AuthCodeURL(state, oidc.Nonce(nonce)))
+	return
+}
+
+// OIDCCallback complete the authorization code flow, the user is provisioned
+// from the id token and receive a token pair like on password login
+func OIDCCallback(c *gin.Context) {
+	if oidcClient == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": ErrOIDCDisabled.Error(),
+		})
+		return
+	}
+
+	state, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || c.Query("state") != state {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid state",
+		})
+		return
+	}
+	nonce, _ := c.Cookie(oidcNonceCookie)
+
+	// state and nonce are single use
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.SetCookie(oidcNonceCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Login refused by provider: " + errorCode,
+		})
+		return
+	}
+
+	identity, err := oidcClient.identity(c.Request.Context(), c.Query("code"), nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid OIDC login",
+		})
+		return
+	}
+
+	// refuse locked account
+	lockedUntil, err := userLockedUntil(identity.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !lockedUntil.IsZero() {
+		c.Header("Retry-After", retryAfter(lockedUntil))
+		c.JSON(http.StatusLocked, gin.H{
+			"message": "Account is locked, try again later",
+		})
+		return
+	}
+
+	user, err := db.ProvisionUser(db.SourceOIDC, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNoGroupMapping):
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "No group of the user is mapped to a class",
+			})
+			return
+		case errors.Is(err, db.ErrAuthSourceMismatch):
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "Username already used by another account",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	completeLogin(c, strconv.Itoa(user.ID), "Success login!")
+	return
+}
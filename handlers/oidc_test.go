@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newMockOIDCProvider serve discovery, jwks and a token endpoint returning an
+// id token with nonce for any code
+func newMockOIDCProvider(t *testing.T, clientId string, nonce string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                server.URL,
+			"authorization_endpoint":                server.URL + "/auth",
+			"token_endpoint":                        server.URL + "/token",
+			"jwks_uri":                              server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                server.URL,
+			"aud":                clientId,
+			"sub":                "f4b1c2",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              nonce,
+			"preferred_username": "budi",
+			"name":               "Budi Santoso",
+			"groups":             []string{"/students", "/clubs/robotics"},
+		})
+		idToken.Header["kid"] = "mock"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "mock-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     signed,
+		})
+	})
+
+	return server
+}
+
+// TestOIDCIdentity verifies the code exchange and the mapping of id token claims
+func TestOIDCIdentity(t *testing.T) {
+	server := newMockOIDCProvider(t, "shopiea", "n-0S6")
+	ctx := context.Background()
+
+	login, err := newOIDCLogin(ctx, OIDCConfig{
+		Issuer:      server.URL,
+		ClientID:    "shopiea",
+		RedirectURL: "http://localhost:9898/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := login.identity(ctx, "code", "n-0S6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "budi" || identity.Name != "Budi Santoso" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if len(identity.Groups) != 2 || identity.Groups[0] != "students" {
+		t.Errorf("expected group paths without leading slash, got %v", identity.Groups)
+	}
+
+	// an id token issued for another login attempt must be refused
+	if _, err := login.identity(ctx, "code", "other"); err != ErrOIDCInvalidNonce {
+		t.Errorf("expected nonce mismatch, got %v", err)
+	}
+}
+
+// TestOIDCWrongAudience verifies id tokens for another client are refused
+func TestOIDCWrongAudience(t *testing.T) {
+	server := newMockOIDCProvider(t, "other-client", "n-0S6")
+	ctx := context.Background()
+
+	login, err := newOIDCLogin(ctx, OIDCConfig{
+		Issuer:      server.URL,
+		ClientID:    "shopiea",
+		RedirectURL: "http://localhost:9898/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := login.identity(ctx, "code", "n-0S6"); err == nil {
+		t.Error("id token with wrong audience should be refused")
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// OverrideScore is a function to set, adjust or clear manually the score of a student on a lab
+func OverrideScore(c *gin.Context) {
+	var request db.OverrideRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	if request.UserID == 0 || request.LabID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "user_id and lab_id cant be empty",
+		})
+		return
+	}
+
+	// check permission on the class of the student and the course of the lab
+	courseId, err := db.GetLabCourseId(request.LabID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Labs Not Found",
+		})
+		return
+	}
+	if !authorize(c, db.PermScoresOverride, db.Scope{UserID: request.UserID, CourseID: courseId}) {
+		return
+	}
+
+	actorId, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	override, err := db.OverrideScore(actorId, request)
+	if err != nil {
+		switch err {
+		case db.ErrReasonRequired:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "reason cant be empty",
+			})
+			return
+		case db.ErrInvalidOverride:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "action must be set (value 0 to max_score), adjust (value -max_score to max_score) or clear",
+			})
+			return
+		case db.ErrNotOverridden:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "score is not overridden",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User or Lab Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"override": override,
+		"message":  "Success override score!",
+	})
+	return
+}
+
+// GetScoreOverrides is a function to get the audit trail of overrides of user_id, filtered by lab_id from query if any
+func GetScoreOverrides(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "user_id must be integer",
+		})
+		return
+	}
+
+	var labId int
+	if lab := c.Query("lab_id"); lab != "" {
+		labId, err = strconv.Atoi(lab)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "lab_id must be integer",
+			})
+			return
+		}
+	}
+
+	overrides, err := db.GetScoreOverrides(userId, labId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"overrides": overrides,
+	})
+	return
+}
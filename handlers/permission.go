@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// ScopeFunc read the class, course or user a request acts on
+type ScopeFunc func(c *gin.Context) (db.Scope, error)
+
+// errInvalidScope is returned by ScopeFunc when the request id is not a number
+var errInvalidScope = errors.New("invalid scope")
+
+// NoScope check the permission without class or course
+func NoScope(c *gin.Context) (db.Scope, error) {
+	return db.Scope{}, nil
+}
+
+// QueryScope read the optional class_id and course_id from query
+func QueryScope(c *gin.Context) (db.Scope, error) {
+	var scope db.Scope
+	var err error
+
+	if classId := c.Query("class_id"); classId != "" {
+		if scope.ClassID, err = strconv.Atoi(classId); err != nil {
+			return scope, errInvalidScope
+		}
+	}
+	if courseId := c.Query("course_id"); courseId != "" {
+		if scope.CourseID, err = strconv.Atoi(courseId); err != nil {
+			return scope, errInvalidScope
+		}
+	}
+
+	return scope, nil
+}
+
+// UserScope read the target user id from query param, the user class is checked
+func UserScope(param string) ScopeFunc {
+	return func(c *gin.Context) (db.Scope, error) {
+		userId, err := strconv.Atoi(c.Query(param))
+		if err != nil {
+			return db.Scope{}, errInvalidScope
+		}
+		return db.Scope{UserID: userId}, nil
+	}
+}
+
+// LabScope read the lab id from query param, the lab course is checked
+func LabScope(param string) ScopeFunc {
+	return func(c *gin.Context) (db.Scope, error) {
+		labId, err := strconv.Atoi(c.Query(param))
+		if err != nil {
+			return db.Scope{}, errInvalidScope
+		}
+		courseId, err := db.GetLabCourseId(labId)
+		if err != nil {
+			return db.Scope{}, err
+		}
+		return db.Scope{CourseID: courseId}, nil
+	}
+}
+
+// AttemptScope read the attempt id from query param, the class of the attempt user is checked
+func AttemptScope(param string) ScopeFunc {
+	return func(c *gin.Context) (db.Scope, error) {
+		attemptId, err := strconv.Atoi(c.Query(param))
+		if err != nil {
+			return db.Scope{}, errInvalidScope
+		}
+		userId, err := db.GetAttemptUserId(attemptId)
+		if err != nil {
+			return db.Scope{}, err
+		}
+		return db.Scope{UserID: userId}, nil
+	}
+}
+
+// RequirePermission allow the request when the user role has permission on the scope of the request
+func RequirePermission(permission string, scopeFunc ScopeFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("userId"); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "Unauthorized!",
+			})
+			return
+		}
+
+		scope, err := scopeFunc(c)
+		if err != nil {
+			switch err {
+			case errInvalidScope:
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"message": "Bad Request",
+				})
+			case db.ErrNotFound:
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"message": "Not Found",
+				})
+			default:
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"message": "Internal Server Error",
+				})
+			}
+			return
+		}
+
+		if !authorize(c, permission, scope) {
+			c.Abort()
+			return
+		}
+
+		if !checkMFAPolicy(c) {
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// authorize check permission of the current user on scope, the error
+// response is written when the user is not allowed
+func authorize(c *gin.Context, permission string, scope db.Scope) bool {
+	userIdInt, err := strconv.Atoi(c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized!",
+		})
+		return false
+	}
+
+	allowed, err := db.HasPermission(userIdInt, permission, scope)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return false
+	}
+
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Forbidden!",
+		})
+		return false
+	}
+
+	return true
+}
+
+// authorizeUserBody check the class and role of a user from request body,
+// only users with roles:manage can give a role other than student
+func authorizeUserBody(c *gin.Context, user db.User) bool {
+	if user.ClassID != 0 && !authorize(c, db.PermUsersWrite, db.Scope{ClassID: user.ClassID}) {
+		return false
+	}
+
+	if user.RoleID == 0 {
+		return true
+	}
+
+	studentRoleId, err := db.GetRoleIdByName(db.RoleStudent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return false
+	}
+
+	if user.RoleID != studentRoleId {
+		return authorize(c, db.PermRolesManage, db.Scope{})
+	}
+
+	return true
+}
+
+// GetRoles is a function to get all roles with their permissions
+func GetRoles(c *gin.Context) {
+	roles, err := db.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+	})
+	return
+}
+
+// CreateAssignment is a function to assign a user to a class and optionally a course
+func CreateAssignment(c *gin.Context) {
+	var assignment db.ClassAssignment
+	if err := c.BindJSON(&assignment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	res, err := db.CreateAssignment(assignment)
+	if err != nil {
+		switch err {
+		case db.ErrCantBeEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "user_id and class_id cant be empty",
+			})
+			return
+		case db.ErrAlreadyExist:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Assignment already exist",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      res.ID,
+		"message": "Success create assignment!",
+	})
+	return
+}
+
+// GetAssignments is a function to get class assignments, filtered by user_id from query if any
+func GetAssignments(c *gin.Context) {
+	var userIdInt int
+	if userId := c.Query("user_id"); userId != "" {
+		var err error
+		userIdInt, err = strconv.Atoi(userId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "user_id must be integer",
+			})
+			return
+		}
+	}
+
+	assignments, err := db.GetAssignments(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"assignments": assignments,
+	})
+	return
+}
+
+// DeleteAssignment is a function to delete assignment by id from query
+func DeleteAssignment(c *gin.Context) {
+	assignmentId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "id must be integer",
+		})
+		return
+	}
+
+	err = db.DeleteAssignmentById(assignmentId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Assignment Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success delete assignment",
+	})
+	return
+}
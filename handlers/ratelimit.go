@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AttemptStore persists failed login attempts by key, the memory store is
+// used by default and db.AttemptStore share the state between instances
+type AttemptStore interface {
+	Get(key string) (db.LoginAttempt, error)
+	Save(attempt db.LoginAttempt) error
+	Purge(before time.Time) error
+}
+
+// LoginLimitConfig is a struct to store login brute-force protection configuration
+type LoginLimitConfig struct {
+	// MaxIPAttempts failures from one ip before it is blocked with 429
+	MaxIPAttempts int
+	// MaxUserAttempts failures for one username before the account is locked with 423
+	MaxUserAttempts int
+	// BaseDelay is the first block duration, doubled on every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+	// Store is memory or database
+	Store string
+}
+
+// defaultLoginLimitConfig is used for every zero value of the configuration
+var defaultLoginLimitConfig = LoginLimitConfig{
+	MaxIPAttempts:   20,
+	MaxUserAttempts: 5,
+	BaseDelay:       30 * time.Second,
+	MaxDelay:        30 * time.Minute,
+	Window:          time.Hour,
+	Store:           "memory",
+}
+
+// loginLimitConfig is the active configuration
+var loginLimitConfig = defaultLoginLimitConfig
+
+// attemptStore keeps the failures per ip and per username
+var attemptStore AttemptStore = newMemoryAttemptStore()
+
+// InitLoginLimiter set the brute-force protection configuration, zero values keep the default
+func (config LoginLimitConfig) InitLoginLimiter() {
+	if config.MaxIPAttempts == 0 {
+		config.MaxIPAttempts = defaultLoginLimitConfig.MaxIPAttempts
+	}
+	if config.MaxUserAttempts == 0 {
+		config.MaxUserAttempts = defaultLoginLimitConfig.MaxUserAttempts
+	}
+	if config.BaseDelay == 0 {
+		config.BaseDelay = defaultLoginLimitConfig.BaseDelay
+	}
+	if config.MaxDelay == 0 {
+		config.MaxDelay = defaultLoginLimitConfig.MaxDelay
+	}
+	if config.Window == 0 {
+		config.Window = defaultLoginLimitConfig.Window
+	}
+
+	switch config.Store {
+	case "database":
+		attemptStore = db.AttemptStore{}
+	default:
+		config.Store = "memory"
+		attemptStore = newMemoryAttemptStore()
+	}
+
+	loginLimitConfig = config
+}
+
+// backoffDelay returns how long to block after failures, starting at
+// BaseDelay when failures reach max and doubling for each further failure
+func backoffDelay(failures int, max int) time.Duration {
+	if failures < max {
+		return 0
+	}
+
+	exponent := float64(failures - max)
+	delay := float64(loginLimitConfig.BaseDelay) * math.Pow(2, exponent)
+	if delay > float64(loginLimitConfig.MaxDelay) {
+		return loginLimitConfig.MaxDelay
+	}
+
+	return time.Duration(delay)
+}
+
+// ipKey and userKey are the attempt store keys of an ip and of a username
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+// blockedUntil returns until when key is blocked, the zero time means not blocked
+func blockedUntil(key string) (time.Time, error) {
+	attempt, err := attemptStore.Get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if time.Now().Before(attempt.BlockedUntil) {
+		return attempt.BlockedUntil, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// ipBlockedUntil returns until when the ip is blocked, the zero time means not blocked
+func ipBlockedUntil(ip string) (time.Time, error) {
+	return blockedUntil(ipKey(ip))
+}
+
+// userLockedUntil returns until when the account of username is locked, the zero time means not locked
+func userLockedUntil(username string) (time.Time, error) {
+	return blockedUntil(userKey(username))
+}
+
+// recordFailure count a failed login of key and block it when max is reached
+func recordFailure(key string, max int) error {
+	attempt, err := attemptStore.Get(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// forget failures older than the window
+	if now.Sub(attempt.UpdatedAt) > loginLimitConfig.Window {
+		attempt.Failures = 0
+	}
+
+	attempt.Failures++
+	attempt.UpdatedAt = now
+	if delay := backoffDelay(attempt.Failures, max); delay > 0 {
+		attempt.BlockedUntil = now.Add(delay)
+	}
+
+	if err := attemptStore.Save(attempt); err != nil {
+		return err
+	}
+
+	return attemptStore.Purge(now.Add(-loginLimitConfig.Window))
+}
+
+// recordIPFailure count a failed login of ip and block it when the limit is reached
+func recordIPFailure(ip string) error {
+	return recordFailure(ipKey(ip), loginLimitConfig.MaxIPAttempts)
+}
+
+// recordUserFailure count a failed login of username and lock the account when the limit is reached,
+// unknown usernames are counted too so a lockout does not tell whether the account exists
+func recordUserFailure(username string) error {
+	return recordFailure(userKey(username), loginLimitConfig.MaxUserAttempts)
+}
+
+// resetFailures forget the failures of every key after a successful login
+func resetFailures(keys ...string) error {
+	for _, key := range keys {
+		if err := attemptStore.Save(db.LoginAttempt{Key: key, UpdatedAt: time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryAfter format the Retry-After header value in seconds, rounded up
+func retryAfter(until time.Time) string {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// memoryAttemptStore is an AttemptStore local to the process
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]db.LoginAttempt
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{attempts: map[string]db.LoginAttempt{}}
+}
+
+func (store *memoryAttemptStore) Get(key string) (db.LoginAttempt, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, ok := store.attempts[key]
+	if !ok {
+		return db.LoginAttempt{Key: key}, nil
+	}
+	return attempt, nil
+}
+
+func (store *memoryAttemptStore) Save(attempt db.LoginAttempt) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.attempts[attempt.Key] = attempt
+	return nil
+}
+
+func (store *memoryAttemptStore) Purge(before time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, attempt := range store.attempts {
+		// keep blocked keys until the block is over
+		if attempt.UpdatedAt.Before(before) && attempt.BlockedUntil.Before(time.Now()) {
+			delete(store.attempts, key)
+		}
+	}
+	return nil
+}
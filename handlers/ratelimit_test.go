@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoffDelay verifies the block duration doubles after the limit and is capped
+func TestBackoffDelay(t *testing.T) {
+	LoginLimitConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second}.InitLoginLimiter()
+	defer LoginLimitConfig{}.InitLoginLimiter()
+
+	cases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tc := range cases {
+		if delay := backoffDelay(tc.failures, 3); delay != tc.expected {
+			t.Errorf("failures %d: expected %s, got %s", tc.failures, tc.expected, delay)
+		}
+	}
+}
+
+// TestIPBlocked verifies an ip is blocked after reaching the limit with the memory store
+func TestIPBlocked(t *testing.T) {
+	LoginLimitConfig{MaxIPAttempts: 3, BaseDelay: time.Minute}.InitLoginLimiter()
+	defer LoginLimitConfig{}.InitLoginLimiter()
+
+	for i := 0; i < 2; i++ {
+		if err := recordIPFailure("10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if until, _ := ipBlockedUntil("10.0.0.1"); !until.IsZero() {
+		t.Error("ip should not be blocked before the limit")
+	}
+
+	if err := recordIPFailure("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	until, _ := ipBlockedUntil("10.0.0.1")
+	if until.IsZero() {
+		t.Fatal("ip should be blocked after the limit")
+	}
+	if retry := retryAfter(until); retry != "60" {
+		t.Errorf("expected Retry-After 60, got %s", retry)
+	}
+
+	// other ip behind a different address are not affected
+	if other, _ := ipBlockedUntil("10.0.0.2"); !other.IsZero() {
+		t.Error("other ip should not be blocked")
+	}
+}
+
+// TestUserLockedAndReset verifies a username is locked through the store and a successful
+// login forgets the failures of its ip and username
+func TestUserLockedAndReset(t *testing.T) {
+	LoginLimitConfig{MaxIPAttempts: 3, MaxUserAttempts: 2, BaseDelay: time.Minute}.InitLoginLimiter()
+	defer LoginLimitConfig{}.InitLoginLimiter()
+
+	for i := 0; i < 2; i++ {
+		if err := recordUserFailure("wayan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordIPFailure("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if until, _ := userLockedUntil("wayan"); until.IsZero() {
+		t.Fatal("username should be locked after the limit")
+	}
+	if until, _ := userLockedUntil("ayu"); !until.IsZero() {
+		t.Error("other username should not be locked")
+	}
+
+	if err := resetFailures(ipKey("10.0.0.1"), userKey("wayan")); err != nil {
+		t.Fatal(err)
+	}
+	if until, _ := userLockedUntil("wayan"); !until.IsZero() {
+		t.Error("username should be unlocked after the reset")
+	}
+
+	// the ip counter starts again from zero
+	for i := 0; i < 2; i++ {
+		if err := recordIPFailure("10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if until, _ := ipBlockedUntil("10.0.0.1"); !until.IsZero() {
+		t.Error("ip failures before the reset should be forgotten")
+	}
+}
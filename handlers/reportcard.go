@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errLogoType = errors.New("report logo must be a PNG, JPEG or GIF image")
+
+// ReportCardConfig is a struct to store PDF report card configuration
+type ReportCardConfig struct {
+	// Logo is the path of an image printed on the top left of every page, none by default
+	Logo string
+	// Header is the text printed on the top of every page, e.g. the name of the institution,
+	// lines are separated by \n
+	Header string
+	// Signer is the title printed above the signature of the report cards, default Instructor
+	Signer string
+}
+
+var defaultReportCardConfig = ReportCardConfig{
+	Signer: "Instructor",
+}
+
+var reportCardConfig = defaultReportCardConfig
+
+// reportLogo is the content of the logo and its gofpdf image type
+var reportLogo struct {
+	data      []byte
+	imageType string
+}
+
+// InitReportCards set the PDF report card configuration and load the logo, zero values keep the default
+func (config ReportCardConfig) InitReportCards() error {
+	if config.Signer == "" {
+		config.Signer = defaultReportCardConfig.Signer
+	}
+	config.Header = strings.ReplaceAll(config.Header, `\n`, "\n")
+
+	reportLogo.data, reportLogo.imageType = nil, ""
+	if config.Logo != "" {
+		data, err := os.ReadFile(config.Logo)
+		if err != nil {
+			return err
+		}
+		switch http.DetectContentType(data) {
+		case "image/png":
+			reportLogo.imageType = "PNG"
+		case "image/jpeg":
+			reportLogo.imageType = "JPG"
+		case "image/gif":
+			reportLogo.imageType = "GIF"
+		default:
+			return errLogoType
+		}
+		reportLogo.data = data
+	}
+
+	reportCardConfig = config
+	return nil
+}
+
+// reportCards are the report cards of a class on a course
+type reportCards struct {
+	class  string
+	course string
+	date   time.Time
+	labs   []db.LabInfo
+	report []db.Report
+}
+
+// writeReportCards write cards as a zip attachment with the class summary and a report card per student
+func writeReportCards(c *gin.Context, filename string, cards reportCards) error {
+	data, err := cards.zip()
+	if err != nil {
+		return err
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, exportContentTypes[formatPDF], data)
+	return nil
+}
+
+// zip returns the class summary as class-summary.pdf and the report card of each student as
+// <no>-<username>.pdf, students are numbered by name like the class summary
+func (cards reportCards) zip() ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	add := func(name string, data []byte) error {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: cards.date})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		return err
+	}
+
+	summary, err := cards.summary()
+	if err != nil {
+		return nil, err
+	}
+	if err := add("class-summary.pdf", summary); err != nil {
+		return nil, err
+	}
+
+	for i, student := range sortReport(cards.report) {
+		card, err := cards.student(student)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(fmt.Sprintf("%02d-%s.pdf", i+1, safeFilename(student.Username)), card); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// student returns the report card of student with the score of every lab, the average, the grade
+// and the signatures of the student and the signer
+func (cards reportCards) student(student db.Report) ([]byte, error) {
+	doc := cards.newDocument("Score Report - " + student.Name)
+	doc.title("Score Report")
+	doc.fields([][2]string{
+		{"Name", student.Name},
+		{"Username", student.Username},
+		{"Class", cards.class},
+		{"Course", cards.course},
+		{"Date", cards.date.Format("2 January 2006")},
+	})
+
+	rows := make([][]string, len(student.Scores))
+	for i, score := range student.Scores {
+		rows[i] = []string{strconv.Itoa(i + 1), score.LabName, formatNumber(score.Score), formatNumber(score.MaxScore), formatNumber(score.Percentage) + "%"}
+	}
+	doc.table([]float64{12, 0, 30, 30, 30}, "CLRRR", []string{"No", "Lab", "Score", "Max score", "Percentage"}, rows)
+
+	doc.fields([][2]string{
+		{"Total", formatNumber(student.Total)},
+		{"Average", formatNumber(student.Average) + "%"},
+		{"Percentage", formatNumber(student.Percentage) + "%"},
+		{"Grade", student.Grade},
+	})
+
+	doc.signatures(cards.date, [2]string{"Student", student.Name}, [2]string{reportCardConfig.Signer, ""})
+
+	return doc.bytes()
+}
+
+// summary returns the class summary with the results of every student and the average of every lab
+func (cards reportCards) summary() ([]byte, error) {
+	doc := cards.newDocument("Class Summary - " + cards.class)
+	doc.title("Class Summary")
+	doc.fields([][2]string{
+		{"Class", cards.class},
+		{"Course", cards.course},
+		{"Students", strconv.Itoa(len(cards.report))},
+		{"Date", cards.date.Format("2 January 2006")},
+	})
+
+	students := sortReport(cards.report)
+	rows := make([][]string, len(students))
+	for i, student := range students {
+		rows[i] = []string{strconv.Itoa(i + 1), student.Username, student.Name, formatNumber(student.Total),
+			formatNumber(student.Average) + "%", formatNumber(student.Percentage) + "%", student.Grade}
+	}
+	doc.table([]float64{12, 35, 0, 22, 22, 25, 16}, "CLLRRRC", []string{"No", "Username", "Name", "Total", "Average", "Percentage", "Grade"}, rows)
+
+	// scores of the report are in the order of labs
+	rows = make([][]string, len(cards.labs))
+	for i, lab := range cards.labs {
+		var score, percentage float64
+		for _, student := range students {
+			score += student.Scores[i].Score
+			percentage += student.Scores[i].Percentage
+		}
+		if len(students) > 0 {
+			score = math.Round(score/float64(len(students))*100) / 100
+			percentage = math.Round(percentage/float64(len(students))*100) / 100
+		}
+		rows[i] = []string{strconv.Itoa(i + 1), lab.Name, formatNumber(lab.MaxScore), formatNumber(score), formatNumber(percentage) + "%"}
+	}
+	doc.table([]float64{12, 0, 30, 30, 30}, "CLRRR", []string{"No", "Lab", "Max score", "Average score", "Average"}, rows)
+
+	doc.signatures(cards.date, [2]string{reportCardConfig.Signer, ""})
+
+	return doc.bytes()
+}
+
+// formatNumber returns value without trailing zeros
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// reportDocument is an A4 PDF with the logo and header of the configuration on every page
+type reportDocument struct {
+	pdf *gofpdf.Fpdf
+	// text convert UTF-8 to the code page of the core fonts
+	text func(string) string
+}
+
+// newDocument returns an empty document titled title, dated like cards
+func (cards reportCards) newDocument(title string) *reportDocument {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	doc := &reportDocument{pdf: pdf, text: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("shopiea", true)
+	pdf.SetCreationDate(cards.date)
+	pdf.SetModificationDate(cards.date)
+	pdf.SetMargins(20, 15, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AliasNbPages("")
+
+	if reportLogo.data != nil {
+		pdf.RegisterImageOptionsReader("logo", gofpdf.ImageOptions{ImageType: reportLogo.imageType}, bytes.NewReader(reportLogo.data))
+	}
+
+	pdf.SetHeaderFunc(func() {
+		left, top, right, _ := pdf.GetMargins()
+		width, _ := pdf.GetPageSize()
+		bottom := top
+		if reportLogo.data != nil {
+			pdf.ImageOptions("logo", left, top, 0, 18, false, gofpdf.ImageOptions{ImageType: reportLogo.imageType}, 0, "")
+			bottom = top + 18
+		}
+		if reportCardConfig.Header != "" {
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.SetXY(left, top)
+			pdf.MultiCell(width-left-right, 6, doc.text(reportCardConfig.Header), "", "C", false)
+			if pdf.GetY() > bottom {
+				bottom = pdf.GetY()
+			}
+		}
+		if bottom > top {
+			pdf.Line(left, bottom+2, width-right, bottom+2)
+			pdf.SetY(bottom + 6)
+		}
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pdf.AddPage()
+	return doc
+}
+
+func (doc *reportDocument) title(title string) {
+	doc.pdf.SetFont("Helvetica", "B", 16)
+	doc.pdf.CellFormat(0, 10, doc.text(title), "", 1, "C", false, 0, "")
+	doc.pdf.Ln(4)
+}
+
+// fields print each label and value on a line
+func (doc *reportDocument) fields(fields [][2]string) {
+	for _, field := range fields {
+		doc.pdf.SetFont("Helvetica", "B", 10)
+		doc.pdf.CellFormat(30, 6, doc.text(field[0]), "", 0, "L", false, 0, "")
+		doc.pdf.SetFont("Helvetica", "", 10)
+		doc.pdf.CellFormat(0, 6, doc.text(": "+field[1]), "", 1, "L", false, 0, "")
+	}
+	doc.pdf.Ln(4)
+}
+
+// table print header and rows with the column widths and aligns, the column of width 0
+// takes the remaining width and its text is shortened to fit. The header is repeated on new pages
+func (doc *reportDocument) table(widths []float64, aligns string, header []string, rows [][]string) {
+	pdf := doc.pdf
+	left, _, right, bottom := pdf.GetMargins()
+	width, height := pdf.GetPageSize()
+
+	widths = append([]float64(nil), widths...)
+	remaining := width - left - right
+	for _, w := range widths {
+		remaining -= w
+	}
+	for i := range widths {
+		if widths[i] == 0 {
+			widths[i] = remaining
+		}
+	}
+
+	printHeader := func() {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for i, name := range header {
+			pdf.CellFormat(widths[i], 7, doc.text(name), "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 10)
+	}
+
+	printHeader()
+	for _, row := range rows {
+		if pdf.GetY()+7 > height-bottom {
+			pdf.AddPage()
+			printHeader()
+		}
+		for i, cell := range row {
+			pdf.CellFormat(widths[i], 7, doc.fit(doc.text(cell), widths[i]-2), "1", 0, aligns[i:i+1], false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(6)
+}
+
+// fit shorten text with an ellipsis to be at most width wide
+func (doc *reportDocument) fit(text string, width float64) string {
+	if doc.pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && doc.pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// signatures print the date and a signature block for each title and name side by side on
+// the right, a blank line is left when the name is empty
+func (doc *reportDocument) signatures(date time.Time, signers ...[2]string) {
+	pdf := doc.pdf
+	left, _, right, bottom := pdf.GetMargins()
+	width, height := pdf.GetPageSize()
+
+	// the block is kept on a single page
+	if pdf.GetY()+45 > height-bottom {
+		pdf.AddPage()
+	}
+
+	columns := len(signers)
+	if columns < 2 {
+		columns = 2
+	}
+	columnWidth := (width - left - right) / float64(columns)
+	left += columnWidth * float64(columns-len(signers))
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetX(left + columnWidth*float64(len(signers)-1))
+	pdf.CellFormat(columnWidth, 6, doc.text(date.Format("2 January 2006")), "", 1, "C", false, 0, "")
+
+	y := pdf.GetY()
+	for i, signer := range signers {
+		x := left + columnWidth*float64(i)
+		pdf.SetXY(x, y)
+		pdf.CellFormat(columnWidth, 6, doc.text(signer[0]), "", 0, "C", false, 0, "")
+		pdf.SetXY(x, y+25)
+		name := signer[1]
+		if name == "" {
+			name = "______________________"
+		}
+		pdf.CellFormat(columnWidth, 6, doc.text("( "+name+" )"), "", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+}
+
+// bytes returns the document, or the first error while building it
+func (doc *reportDocument) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := doc.pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/Kyuubang/shopiea/db"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReportCardsZip verifies the zip holds the class summary and a PDF per student numbered by name,
+// with the logo and header of the configuration
+func TestReportCardsZip(t *testing.T) {
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logo, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := ReportCardConfig{Logo: logo, Header: `SMK Negeri 1 Denpasar\nTeknik Komputer dan Jaringan`}
+	if err := config.InitReportCards(); err != nil {
+		t.Fatal(err)
+	}
+	defer defaultReportCardConfig.InitReportCards()
+
+	labs := []db.LabInfo{{ID: 1, Name: "lab-1", MaxScore: 100}, {ID: 2, Name: "lab-2", MaxScore: 50}}
+	cards := reportCards{
+		class:  "XII TKJ 1",
+		course: "Linux",
+		date:   time.Date(2026, 6, 20, 10, 0, 0, 0, time.UTC),
+		labs:   labs,
+		report: []db.Report{
+			{Username: "wayan", Name: "I Wayan Sudirta", Scores: []db.ScoreLabs{{LabName: "lab-1", Score: 90, MaxScore: 100, Percentage: 90}, {LabName: "lab-2", Score: 25, MaxScore: 50, Percentage: 50}}, Grade: "C"},
+			{Username: "ayu", Name: "Ayu Ñoman Pratiwi", Scores: []db.ScoreLabs{{LabName: "lab-1", Score: 100, MaxScore: 100, Percentage: 100}, {LabName: "lab-2", Score: 50, MaxScore: 50, Percentage: 100}}, Grade: "A"},
+		},
+	}
+
+	data, err := cards.zip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"class-summary.pdf", "01-ayu.pdf", "02-wayan.pdf"}
+	if len(archive.File) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(archive.File))
+	}
+	for i, file := range archive.File {
+		if file.Name != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], file.Name)
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content bytes.Buffer
+		if _, err := content.ReadFrom(reader); err != nil {
+			t.Fatal(err)
+		}
+		reader.Close()
+		if !strings.HasPrefix(content.String(), "%PDF-") {
+			t.Errorf("%s is not a PDF", file.Name)
+		}
+		if !strings.Contains(content.String(), "/Subtype /Image") {
+			t.Errorf("%s has no logo", file.Name)
+		}
+	}
+}
+
+// TestReportCardsLogoType verifies a logo which is not an image is refused
+func TestReportCardsLogoType(t *testing.T) {
+	logo := filepath.Join(t.TempDir(), "logo.txt")
+	if err := os.WriteFile(logo, []byte("not an image"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (ReportCardConfig{Logo: logo}).InitReportCards(); err != errLogoType {
+		t.Errorf("expected %v, got %v", errLogoType, err)
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config is a struct to store the bucket of the s3 artifact store
+type S3Config struct {
+	// Endpoint is the url of the S3-compatible server, e.g. http://localhost:9000 for MinIO
+	Endpoint string
+	Bucket   string
+	// Region default us-east-1, MinIO accepts any region
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// s3BlobStore is a BlobStore in a bucket of an S3-compatible server, requests use
+// path-style urls and are signed with AWS signature version 4
+type s3BlobStore struct {
+	endpoint *url.URL
+	config   S3Config
+	client   *http.Client
+}
+
+func newS3BlobStore(config S3Config) (s3BlobStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return s3BlobStore{}, errors.New("s3 artifact store needs endpoint, bucket, access key and secret key")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return s3BlobStore{}, errors.New("invalid s3 endpoint " + config.Endpoint)
+	}
+
+	return s3BlobStore{
+		endpoint: endpoint,
+		config:   config,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (store s3BlobStore) Put(key string, contentType string, data []byte) error {
+	res, err := store.do(http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (store s3BlobStore) Get(key string) (io.ReadCloser, error) {
+	res, err := store.do(http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, errBlobNotFound
+	default:
+		defer res.Body.Close()
+		return nil, s3Error(res)
+	}
+}
+
+func (store s3BlobStore) Delete(key string) error {
+	res, err := store.do(http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// s3 answers 204 even when the key does not exist
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+// do send a signed request on key of the bucket
+func (store s3BlobStore) do(method string, key string, contentType string, data []byte) (*http.Response, error) {
+	target := *store.endpoint
+	target.Path = strings.TrimRight(target.Path, "/") + "/" + store.config.Bucket + "/" + key
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	payloadHash := sha256.Sum256(data)
+	signV4(req, hex.EncodeToString(payloadHash[:]), store.config, time.Now())
+
+	return store.client.Do(req)
+}
+
+// s3Error returns the status and the start of the error document of res
+func s3Error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("s3 request failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// signV4 add the AWS signature version 4 of req to its Authorization header, every
+// header already set is signed with host, x-amz-date and x-amz-content-sha256
+func signV4(req *http.Request, payloadHash string, config S3Config, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath escape every byte of path except unreserved characters and slashes
+func s3EscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		b := path[i]
+		if b == '/' || b == '-' || b == '_' || b == '.' || b == '~' ||
+			('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+
+	return escaped.String()
+}
+
+// s3CanonicalQuery sort and escape the query parameters
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3EscapePath(name)+"="+s3EscapePath(value))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.ReplaceAll(strings.Join(params, "&"), "/", "%2F")
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// authorizeLabClass check labs:write on the course of labId for classId
+func authorizeLabClass(c *gin.Context, labId int, classId int) bool {
+	courseId, err := db.GetLabCourseId(labId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Labs Not Found",
+			})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return false
+	}
+
+	return authorize(c, db.PermLabsWrite, db.Scope{ClassID: classId, CourseID: courseId})
+}
+
+// SetLabSchedule is a function to set the window of a lab for a class
+func SetLabSchedule(c *gin.Context) {
+	var schedule db.LabSchedule
+	if err := c.BindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	if schedule.LabID == 0 || schedule.ClassID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "lab_id and class_id cant be empty",
+		})
+		return
+	}
+
+	if !authorizeLabClass(c, schedule.LabID, schedule.ClassID) {
+		return
+	}
+
+	res, err := db.SetLabSchedule(schedule)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidSchedule:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "opens_at, due_at and closes_at must be in order",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedule": res,
+		"message":  "Success set lab schedule!",
+	})
+	return
+}
+
+// GetLabSchedules is a function to get class schedules of lab_id from query
+func GetLabSchedules(c *gin.Context) {
+	labId, err := strconv.Atoi(c.Query("lab_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "lab_id must be integer",
+		})
+		return
+	}
+
+	schedules, err := db.GetLabSchedules(labId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+	})
+	return
+}
+
+// DeleteLabSchedule is a function to remove the schedule of lab_id for class_id from query
+func DeleteLabSchedule(c *gin.Context) {
+	labId, err := strconv.Atoi(c.Query("lab_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "lab_id must be integer",
+		})
+		return
+	}
+	classId, err := strconv.Atoi(c.Query("class_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "class_id must be integer",
+		})
+		return
+	}
+
+	if !authorizeLabClass(c, labId, classId) {
+		return
+	}
+
+	err = db.DeleteLabSchedule(labId, classId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Schedule Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success delete lab schedule",
+	})
+	return
+}
@@ -1,36 +1,97 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/Kyuubang/shopiea/db"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // PushScore endpoint to push score
 func PushScore(c *gin.Context) {
-	// Bind the JSON payload to a User struct
-	var score db.ScorePush
-	if err := c.BindJSON(&score); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Bad Request",
-		})
-		return
+	// Bind the JSON payload, or the score field of a multipart payload with an artifact
+	score, upload, err := bindScorePush(c)
+	if err != nil {
+		switch err {
+		case errArtifactTooLarge:
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"message": "artifact is larger than " + strconv.FormatInt(artifactConfig.MaxSize, 10) + " bytes",
+			})
+			return
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Bad Request",
+			})
+			return
+		}
 	}
 
-	userId := c.MustGet("userId").(string)
+	// the artifact is stored first and attached to the attempt by the push
+	if upload != nil {
+		score.Artifact, err = storeArtifact(upload)
+		if err != nil {
+			if err == errArtifactType {
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{
+					"message": "artifact content type not allowed",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
 
-	// convert userId to int
-	userIdInt, err := strconv.Atoi(userId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal Server Error",
+	// every push is recorded as an attempt with where it came from
+	score.IP = c.ClientIP()
+	if score.ClientVersion == "" {
+		score.ClientVersion = c.GetHeader("X-Client-Version")
+	}
+
+	if apiKeyId, ok := c.Get("apiKeyId"); ok {
+		// push score on behalf of a student with an api key
+		err = db.PushScoreByAPIKey(apiKeyId.(int), score)
+	} else {
+		userId := c.MustGet("userId").(string)
+
+		// convert userId to int
+		userIdInt, convErr := strconv.Atoi(userId)
+		if convErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+
+		// push score
+		err = db.PushScore(userIdInt, score)
+	}
+	if score.Artifact != nil {
+		if err == db.ScoreUpdated || err == db.ScoreNotUpdated {
+			_ = purgeArtifacts(time.Now())
+		} else {
+			// the attempt was refused, its artifact is not kept
+			_ = blobStore.Delete(score.Artifact.Key)
+		}
+	}
+	var windowErr *db.LabWindowError
+	if errors.As(err, &windowErr) {
+		if windowErr.Err == db.ErrLabNotOpen {
+			c.JSON(http.StatusForbidden, gin.H{
+				"message":  "lab is not open yet",
+				"opens_at": windowErr.At,
+			})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"message":   "lab is closed, submissions are no longer accepted",
+			"closes_at": windowErr.At,
 		})
 		return
 	}
-
-	// push score
-	err = db.PushScore(userIdInt, score)
 	if err != nil {
 		switch err {
 		case db.ErrCantBeEmpty:
@@ -38,6 +99,46 @@ func PushScore(c *gin.Context) {
 				"message": "user_id, labs_id, score cant be empty",
 			})
 			return
+		case db.ErrScoreInvalid:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "score must be between 0 and max_score of the lab, whole unless the lab allows fractions",
+			})
+			return
+		case db.ErrInvalidChecks:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "checks need unique names and points between 0 and max_points",
+			})
+			return
+		case db.ErrChecksMismatch:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "points of checks must sum to score",
+			})
+			return
+		case db.ErrSignatureRequired:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "this course only accepts signed submissions",
+			})
+			return
+		case db.ErrInvalidSignature:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "invalid signature",
+			})
+			return
+		case db.ErrSignatureExpired:
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "timestamp out of window, check the clock of the client",
+			})
+			return
+		case db.ErrNonceReused:
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "nonce already used",
+			})
+			return
+		case db.ErrUnauthorized, db.ErrCourseNotAllowed:
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "not allowed to push score for this user or lab",
+			})
+			return
 		case db.ScoreUpdated:
 			c.JSON(http.StatusCreated, gin.H{
 				"message": "score updated",
@@ -45,7 +146,7 @@ func PushScore(c *gin.Context) {
 			return
 		case db.ScoreNotUpdated:
 			c.JSON(http.StatusAccepted, gin.H{
-				"message": "keep high score",
+				"message": "attempt recorded, score unchanged",
 			})
 			return
 		default:
@@ -101,6 +202,69 @@ func GetScore(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"lab_name": score.LabName,
 		"score":    score.Score,
+		"penalty":  score.Penalty,
+		"checks":   score.Checks,
+	})
+	return
+}
+
+// GetAttempts endpoint for user to get own attempts, optionally of lab_name from query
+func GetAttempts(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	attempts, err := db.GetAttempts(userIdInt, c.Query("lab_name"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Lab Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attempts": attempts,
+	})
+	return
+}
+
+// GetUserAttempts endpoint to get the attempt timeline of user_id from query, optionally of lab_name
+func GetUserAttempts(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "user_id must be integer",
+		})
+		return
+	}
+
+	attempts, err := db.GetAttempts(userIdInt, c.Query("lab_name"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Lab Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userIdInt,
+		"attempts": attempts,
 	})
 	return
 }
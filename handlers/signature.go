@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// RotateLabSecret is a function to generate a new signing secret for lab id from query,
+// the secret is only shown here and is meant to be distributed with the case repo
+func RotateLabSecret(c *gin.Context) {
+	labId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "id must be integer",
+		})
+		return
+	}
+
+	secret, err := db.RotateLabSecret(labId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Labs Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":  secret,
+		"message": "Success rotate lab secret, previous signatures are no longer valid",
+	})
+	return
+}
+
+// ClearLabSecret is a function to remove the signing secret of lab id from query
+func ClearLabSecret(c *gin.Context) {
+	labId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "id must be integer",
+		})
+		return
+	}
+
+	err = db.ClearLabSecret(labId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Labs Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success clear lab secret, the course secret is used",
+	})
+	return
+}
+
+// RotateCourseSecret is a function to generate a new signing secret for course id from query
+func RotateCourseSecret(c *gin.Context) {
+	courseId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "id must be integer",
+		})
+		return
+	}
+
+	secret, err := db.RotateCourseSecret(courseId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Course Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":  secret,
+		"message": "Success rotate course secret, previous signatures are no longer valid",
+	})
+	return
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"github.com/Kyuubang/shopiea/db"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StreamConfig is a struct to store live score stream configuration
+type StreamConfig struct {
+	// Heartbeat is the interval of keep-alive comments sent to idle listeners, default 15s
+	Heartbeat time.Duration
+}
+
+var defaultStreamConfig = StreamConfig{
+	Heartbeat: 15 * time.Second,
+}
+
+var streamConfig = defaultStreamConfig
+
+var scoreStream = newScoreHub()
+
+// InitStream set the live score stream configuration and publish score changes
+// of the db package to listeners, zero values keep the default
+func (config StreamConfig) InitStream() {
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = defaultStreamConfig.Heartbeat
+	}
+
+	streamConfig = config
+	scoreStream.start()
+	db.SetScoreHook(scoreStream.publish)
+}
+
+// leaderboardKey is the class and course a listener follows
+type leaderboardKey struct {
+	classId  int
+	courseId int
+}
+
+// streamMessage is a server-sent event
+type streamMessage struct {
+	event string
+	data  interface{}
+}
+
+// scoreSubscriber is a listener of the score events of a class and course
+type scoreSubscriber struct {
+	key         leaderboardKey
+	leaderboard bool
+	messages    chan streamMessage
+}
+
+// scoreHub dispatch score events to subscribers, the leaderboard of a class and course
+// is loaded once per change and shared by every subscriber and GetLeaderboard
+type scoreHub struct {
+	mu          sync.Mutex
+	once        sync.Once
+	started     bool
+	events      chan db.ScoreEvent
+	subscribers map[*scoreSubscriber]bool
+	boards      map[leaderboardKey][]db.LeaderboardEntry
+	// versions is incremented on each change to not cache a leaderboard loaded before it
+	versions map[leaderboardKey]int
+}
+
+func newScoreHub() *scoreHub {
+	return &scoreHub{
+		events:      make(chan db.ScoreEvent, 1024),
+		subscribers: map[*scoreSubscriber]bool{},
+		boards:      map[leaderboardKey][]db.LeaderboardEntry{},
+		versions:    map[leaderboardKey]int{},
+	}
+}
+
+// start dispatch published events in the background
+func (hub *scoreHub) start() {
+	hub.once.Do(func() {
+		hub.mu.Lock()
+		hub.started = true
+		hub.mu.Unlock()
+
+		go func() {
+			for event := range hub.events {
+				hub.dispatch(event)
+			}
+		}()
+	})
+}
+
+// publish queue event without blocking the push, events are dropped when the queue is full
+func (hub *scoreHub) publish(event db.ScoreEvent) {
+	select {
+	case hub.events <- event:
+	default:
+	}
+}
+
+func (hub *scoreHub) subscribe(key leaderboardKey, leaderboard bool) *scoreSubscriber {
+	subscriber := &scoreSubscriber{key: key, leaderboard: leaderboard, messages: make(chan streamMessage, 64)}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.subscribers[subscriber] = true
+
+	return subscriber
+}
+
+func (hub *scoreHub) unsubscribe(subscriber *scoreSubscriber) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.subscribers, subscriber)
+}
+
+// dispatch send event to the subscribers of its class and course, with the new leaderboard when asked
+func (hub *scoreHub) dispatch(event db.ScoreEvent) {
+	key := leaderboardKey{classId: event.ClassID, courseId: event.CourseID}
+
+	hub.mu.Lock()
+	delete(hub.boards, key)
+	hub.versions[key]++
+	var subscribers []*scoreSubscriber
+	var leaderboard bool
+	for subscriber := range hub.subscribers {
+		if subscriber.key == key {
+			subscribers = append(subscribers, subscriber)
+			leaderboard = leaderboard || subscriber.leaderboard
+		}
+	}
+	hub.mu.Unlock()
+
+	var board []db.LeaderboardEntry
+	var err error
+	if leaderboard {
+		board, err = hub.leaderboard(key)
+	}
+
+	for _, subscriber := range subscribers {
+		subscriber.send(streamMessage{event: "score", data: event})
+		if subscriber.leaderboard && err == nil {
+			subscriber.send(streamMessage{event: "leaderboard", data: board})
+		}
+	}
+}
+
+// invalidate drop the cached leaderboards of classId, e.g. after a student opted out
+func (hub *scoreHub) invalidate(classId int) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for key := range hub.boards {
+		if key.classId == classId {
+			delete(hub.boards, key)
+			hub.versions[key]++
+		}
+	}
+}
+
+// leaderboard returns the cached leaderboard of key, loaded when a score changed since the last
+// call. Leaderboards are only cached once the hub receive score changes
+func (hub *scoreHub) leaderboard(key leaderboardKey) ([]db.LeaderboardEntry, error) {
+	hub.mu.Lock()
+	board, ok := hub.boards[key]
+	version := hub.versions[key]
+	hub.mu.Unlock()
+	if ok {
+		return board, nil
+	}
+
+	board, err := db.GetLeaderboard(key.classId, key.courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	hub.mu.Lock()
+	if hub.started && hub.versions[key] == version {
+		hub.boards[key] = board
+	}
+	hub.mu.Unlock()
+
+	return board, nil
+}
+
+// send queue message without blocking the hub, a slow listener misses messages
+func (subscriber *scoreSubscriber) send(message streamMessage) {
+	select {
+	case subscriber.messages <- message:
+	default:
+	}
+}
+
+// serverWriterKey is the context key of the response writer of the server
+type serverWriterKey struct{}
+
+// StreamHandler keep the response writer of the server in the request context, so
+// streams can clear the write timeout of the server
+func StreamHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverWriterKey{}, w)))
+	})
+}
+
+// leaderboardQuery read the class_id and course_id from query
+func leaderboardQuery(c *gin.Context) (leaderboardKey, bool) {
+	classId, err := strconv.Atoi(c.Query("class_id"))
+	if err != nil {
+		return leaderboardKey{}, false
+	}
+	courseId, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		return leaderboardKey{}, false
+	}
+
+	return leaderboardKey{classId: classId, courseId: courseId}, true
+}
+
+// GetLeaderboard endpoint to get the students of class_id ranked by percentage on the labs of course_id
+func GetLeaderboard(c *gin.Context) {
+	key, ok := leaderboardQuery(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "class_id and course_id must be integer",
+		})
+		return
+	}
+
+	board, err := scoreStream.leaderboard(key)
+	if err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Class or Course Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"class_id":    key.classId,
+		"course_id":   key.courseId,
+		"leaderboard": board,
+	})
+	return
+}
+
+// StreamScores endpoint to stream the score changes of class_id on course_id as server-sent events,
+// with the leaderboard after each change when leaderboard=true
+func StreamScores(c *gin.Context) {
+	key, ok := leaderboardQuery(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "class_id and course_id must be integer",
+		})
+		return
+	}
+
+	// the leaderboard is loaded first to refuse unknown class or course before streaming
+	board, err := scoreStream.leaderboard(key)
+	if err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Class or Course Not Found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	// a stream outlives the write timeout of the server
+	if w, ok := c.Request.Context().Value(serverWriterKey{}).(http.ResponseWriter); ok {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+
+	withLeaderboard := c.Query("leaderboard") == "true"
+	subscriber := scoreStream.subscribe(key, withLeaderboard)
+	defer scoreStream.unsubscribe(subscriber)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	if withLeaderboard {
+		subscriber.send(streamMessage{event: "leaderboard", data: board})
+	}
+
+	heartbeat := time.NewTicker(streamConfig.Heartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case message := <-subscriber.messages:
+			c.SSEvent(message.event, message.data)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		}
+	})
+	return
+}
+
+// SetLeaderboardOptOut endpoint for user to be anonymized on leaderboards and live streams
+func SetLeaderboardOptOut(c *gin.Context) {
+	var preference db.LeaderboardPreference
+	if err := c.BindJSON(&preference); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	userIdInt, err := strconv.Atoi(c.MustGet("userId").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	if err := db.SetLeaderboardOptOut(user.ID, preference.OptOut); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	scoreStream.invalidate(user.ClassID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success update leaderboard preference",
+		"opt_out": preference.OptOut,
+	})
+	return
+}
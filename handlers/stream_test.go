@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"github.com/Kyuubang/shopiea/db"
+	"testing"
+	"time"
+)
+
+// TestScoreHubDispatch verifies events only reach the subscribers of their class and course
+func TestScoreHubDispatch(t *testing.T) {
+	hub := newScoreHub()
+	hub.start()
+
+	follower := hub.subscribe(leaderboardKey{classId: 1, courseId: 2}, false)
+	other := hub.subscribe(leaderboardKey{classId: 1, courseId: 3}, false)
+	defer hub.unsubscribe(follower)
+	defer hub.unsubscribe(other)
+
+	hub.publish(db.ScoreEvent{ClassID: 1, CourseID: 2, Lab: "lab-1", Score: 80})
+
+	select {
+	case message := <-follower.messages:
+		event, ok := message.data.(db.ScoreEvent)
+		if message.event != "score" || !ok || event.Lab != "lab-1" || event.Score != 80 {
+			t.Errorf("unexpected message %+v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a score event")
+	}
+
+	select {
+	case message := <-other.messages:
+		t.Errorf("unexpected message for another course %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/Kyuubang/shopiea/db"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -31,6 +32,11 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	// check the class and role of the new user against the caller permissions
+	if !authorizeUserBody(c, user) {
+		return
+	}
+
 	// create user
 	res, err := db.CreateUser(user)
 	if err != nil {
@@ -80,6 +86,19 @@ func GetUsers(c *gin.Context) {
 		return
 	}
 
+	for i := range users {
+		lockedUntil, err := userLockedUntil(users[i].Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		if !lockedUntil.IsZero() {
+			users[i].LockedUntil = &lockedUntil
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"class":    className,
 		"students": users,
@@ -148,9 +167,64 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// check the new class and role against the caller permissions
+	if !authorizeUserBody(c, user) {
+		return
+	}
+
 	// update user
 	err = db.UpdateUsersByUsersId(userIdInt, user)
 	if err != nil {
+		switch err {
+		case db.ErrCantBeEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "name, username, class_id, role_id cant be empty",
+			})
+			return
+		case db.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success update user",
+	})
+	return
+}
+
+// ResetPassword is a function to replace user password with a temporary one,
+// the user must change it on next login
+func ResetPassword(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	tempPassword, expiresAt, err := db.ResetPassword(userIdInt)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "User Not Found",
+			})
+			return
+		}
+		if errors.Is(err, db.ErrExternalAccount) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Password is managed by the external login provider",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal Server Error",
 		})
@@ -158,7 +232,40 @@ func UpdateUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Success update user",
+		"temporary_password": tempPassword,
+		"expires_at":         expiresAt,
+		"message":            "Success reset password",
+	})
+	return
+}
+
+// UnlockUser is a function to remove the login lockout of user_id from query
+func UnlockUser(c *gin.Context) {
+	userIdInt, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request",
+		})
+		return
+	}
+
+	user, err := db.GetUserById(userIdInt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User Not Found",
+		})
+		return
+	}
+
+	if err := resetFailures(userKey(user.Username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Success unlock user",
 	})
 	return
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"github.com/Kyuubang/shopiea/db"
@@ -10,6 +11,7 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -44,6 +46,177 @@ func main() {
 		panic(err)
 	}
 
+	// init password hashing, invalid or empty numbers keep the default
+	var passwordConfig = db.PasswordConfig{
+		Algorithm: os.Getenv("SHOPIEA_PASSWORD_ALGORITHM"),
+	}
+	passwordConfig.BcryptCost, _ = strconv.Atoi(os.Getenv("SHOPIEA_BCRYPT_COST"))
+	if memory, err := strconv.ParseUint(os.Getenv("SHOPIEA_ARGON2_MEMORY"), 10, 32); err == nil {
+		passwordConfig.Argon2Memory = uint32(memory)
+	}
+	if iterations, err := strconv.ParseUint(os.Getenv("SHOPIEA_ARGON2_TIME"), 10, 32); err == nil {
+		passwordConfig.Argon2Time = uint32(iterations)
+	}
+	if threads, err := strconv.ParseUint(os.Getenv("SHOPIEA_ARGON2_THREADS"), 10, 8); err == nil {
+		passwordConfig.Argon2Threads = uint8(threads)
+	}
+	passwordConfig.TempPasswordTTL, _ = time.ParseDuration(os.Getenv("SHOPIEA_TEMP_PASSWORD_TTL"))
+
+	err = passwordConfig.InitPasswordHasher()
+	if err != nil {
+		panic(err)
+	}
+
+	// init login backends and group mapping of provisioned users
+	var authConfig = db.AuthConfig{
+		Backends: os.Getenv("SHOPIEA_AUTH_BACKENDS"),
+		GroupMap: os.Getenv("SHOPIEA_AUTH_GROUP_MAP"),
+		LDAP: db.LDAPConfig{
+			URL:               os.Getenv("SHOPIEA_LDAP_URL"),
+			StartTLS:          os.Getenv("SHOPIEA_LDAP_STARTTLS") == "true",
+			BindDN:            os.Getenv("SHOPIEA_LDAP_BIND_DN"),
+			BindPassword:      os.Getenv("SHOPIEA_LDAP_BIND_PASSWORD"),
+			BaseDN:            os.Getenv("SHOPIEA_LDAP_BASE_DN"),
+			UserFilter:        os.Getenv("SHOPIEA_LDAP_USER_FILTER"),
+			UsernameAttribute: os.Getenv("SHOPIEA_LDAP_USERNAME_ATTR"),
+			NameAttribute:     os.Getenv("SHOPIEA_LDAP_NAME_ATTR"),
+			GroupAttribute:    os.Getenv("SHOPIEA_LDAP_GROUP_ATTR"),
+		},
+	}
+
+	err = authConfig.InitAuthBackends()
+	if err != nil {
+		panic(err)
+	}
+
+	// init openid connect login, disabled without issuer
+	var oidcConfig = handlers.OIDCConfig{
+		Issuer:        os.Getenv("SHOPIEA_OIDC_ISSUER"),
+		ClientID:      os.Getenv("SHOPIEA_OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("SHOPIEA_OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("SHOPIEA_OIDC_REDIRECT_URL"),
+		Scopes:        os.Getenv("SHOPIEA_OIDC_SCOPES"),
+		UsernameClaim: os.Getenv("SHOPIEA_OIDC_USERNAME_CLAIM"),
+		NameClaim:     os.Getenv("SHOPIEA_OIDC_NAME_CLAIM"),
+		GroupsClaim:   os.Getenv("SHOPIEA_OIDC_GROUPS_CLAIM"),
+	}
+
+	err = oidcConfig.InitOIDC(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	// init scoring policy
+	var scoringConfig = db.ScoringConfig{
+		Policy: os.Getenv("SHOPIEA_SCORE_POLICY"),
+	}
+	scoringConfig.PolicyN, _ = strconv.Atoi(os.Getenv("SHOPIEA_SCORE_POLICY_N"))
+	scoringConfig.UnlockOverrides = os.Getenv("SHOPIEA_OVERRIDE_LOCK") == "false"
+
+	err = scoringConfig.InitScoring()
+	if err != nil {
+		panic(err)
+	}
+
+	// init course grade scale and lab pass percentage, invalid or empty value keep the default
+	var gradingConfig = db.GradingConfig{
+		Scale: os.Getenv("SHOPIEA_GRADE_SCALE"),
+	}
+	gradingConfig.PassPercentage, _ = strconv.ParseFloat(os.Getenv("SHOPIEA_PASS_PERCENTAGE"), 64)
+
+	err = gradingConfig.InitGrading()
+	if err != nil {
+		panic(err)
+	}
+
+	// init signed score submissions, invalid or empty value keep the default
+	var signingConfig = db.SigningConfig{}
+	signingConfig.Skew, _ = time.ParseDuration(os.Getenv("SHOPIEA_SIGNATURE_SKEW"))
+	signingConfig.InitSigning()
+
+	// init jwt signing keys
+	var jwtConfig = handlers.JWTConfig{
+		Algorithm:      os.Getenv("SHOPIEA_JWT_ALG"),
+		KeyID:          os.Getenv("SHOPIEA_JWT_KID"),
+		Secret:         os.Getenv("SHOPIEA_JWT_SECRET"),
+		SecretFile:     os.Getenv("SHOPIEA_JWT_SECRET_FILE"),
+		PrivateKeyFile: os.Getenv("SHOPIEA_JWT_PRIVATE_KEY_FILE"),
+		VerifyKeys:     os.Getenv("SHOPIEA_JWT_VERIFY_KEYS"),
+	}
+
+	// token lifetime, invalid or empty value keep the default
+	jwtConfig.AccessTTL, _ = time.ParseDuration(os.Getenv("SHOPIEA_JWT_ACCESS_TTL"))
+	jwtConfig.RefreshTTL, _ = time.ParseDuration(os.Getenv("SHOPIEA_JWT_REFRESH_TTL"))
+
+	err = jwtConfig.InitJWT()
+	if err != nil {
+		panic(err)
+	}
+
+	// init login brute-force protection, invalid or empty value keep the default
+	var loginLimitConfig = handlers.LoginLimitConfig{
+		Store: os.Getenv("SHOPIEA_LOGIN_ATTEMPT_STORE"),
+	}
+	loginLimitConfig.MaxIPAttempts, _ = strconv.Atoi(os.Getenv("SHOPIEA_LOGIN_MAX_IP_ATTEMPTS"))
+	loginLimitConfig.MaxUserAttempts, _ = strconv.Atoi(os.Getenv("SHOPIEA_LOGIN_MAX_USER_ATTEMPTS"))
+	loginLimitConfig.BaseDelay, _ = time.ParseDuration(os.Getenv("SHOPIEA_LOGIN_BASE_DELAY"))
+	loginLimitConfig.MaxDelay, _ = time.ParseDuration(os.Getenv("SHOPIEA_LOGIN_MAX_DELAY"))
+	loginLimitConfig.Window, _ = time.ParseDuration(os.Getenv("SHOPIEA_LOGIN_WINDOW"))
+	loginLimitConfig.InitLoginLimiter()
+
+	// init two-factor authentication, invalid or empty value keep the default
+	var mfaConfig = handlers.MFAConfig{
+		RequiredRoles: os.Getenv("SHOPIEA_MFA_REQUIRED_ROLES"),
+		Issuer:        os.Getenv("SHOPIEA_MFA_ISSUER"),
+	}
+	mfaConfig.PendingTTL, _ = time.ParseDuration(os.Getenv("SHOPIEA_MFA_PENDING_TTL"))
+	mfaConfig.InitMFA()
+
+	// init idempotency keys, invalid or empty value keep the default
+	var idempotencyConfig = handlers.IdempotencyConfig{
+		Store: os.Getenv("SHOPIEA_IDEMPOTENCY_STORE"),
+	}
+	idempotencyConfig.TTL, _ = time.ParseDuration(os.Getenv("SHOPIEA_IDEMPOTENCY_TTL"))
+	idempotencyConfig.MaxBody, _ = strconv.ParseInt(os.Getenv("SHOPIEA_IDEMPOTENCY_MAX_BODY"), 10, 64)
+	idempotencyConfig.InitIdempotency()
+
+	// init attempt artifact uploads, invalid or empty value keep the default
+	var artifactConfig = handlers.ArtifactConfig{
+		Store:        os.Getenv("SHOPIEA_ARTIFACT_STORE"),
+		Dir:          os.Getenv("SHOPIEA_ARTIFACT_DIR"),
+		ContentTypes: os.Getenv("SHOPIEA_ARTIFACT_TYPES"),
+		S3: handlers.S3Config{
+			Endpoint:  os.Getenv("SHOPIEA_S3_ENDPOINT"),
+			Bucket:    os.Getenv("SHOPIEA_S3_BUCKET"),
+			Region:    os.Getenv("SHOPIEA_S3_REGION"),
+			AccessKey: os.Getenv("SHOPIEA_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("SHOPIEA_S3_SECRET_KEY"),
+		},
+	}
+	artifactConfig.MaxSize, _ = strconv.ParseInt(os.Getenv("SHOPIEA_ARTIFACT_MAX_SIZE"), 10, 64)
+	artifactConfig.Retention, _ = time.ParseDuration(os.Getenv("SHOPIEA_ARTIFACT_RETENTION"))
+
+	err = artifactConfig.InitArtifacts()
+	if err != nil {
+		panic(err)
+	}
+
+	// init live score stream, invalid or empty value keep the default
+	var streamConfig = handlers.StreamConfig{}
+	streamConfig.Heartbeat, _ = time.ParseDuration(os.Getenv("SHOPIEA_STREAM_HEARTBEAT"))
+	streamConfig.InitStream()
+
+	// init PDF report cards
+	var reportCardConfig = handlers.ReportCardConfig{
+		Logo:   os.Getenv("SHOPIEA_REPORT_LOGO"),
+		Header: os.Getenv("SHOPIEA_REPORT_HEADER"),
+		Signer: os.Getenv("SHOPIEA_REPORT_SIGNER"),
+	}
+	err = reportCardConfig.InitReportCards()
+	if err != nil {
+		panic(err)
+	}
+
 	// init router
 	var router *gin.Engine
 
@@ -64,6 +237,16 @@ func main() {
 	// authentication endpoints
 	// handlers for login user
 	router.POST("/auth/login", handlers.Login)
+	// handlers for second login step with a TOTP or recovery code
+	router.POST("/auth/login/mfa", handlers.LoginMFA)
+	// handlers for renew access token with refresh token
+	router.POST("/auth/refresh", handlers.Refresh)
+	// handlers for revoke current tokens
+	router.POST("/auth/logout", handlers.AuthMiddleware(), handlers.Logout)
+	// handlers for redirect to the openid connect provider
+	router.GET("/auth/oidc/login", handlers.OIDCLogin)
+	// handlers for login with the openid connect authorization code
+	router.GET("/auth/oidc/callback", handlers.OIDCCallback)
 
 	// handlers for anonymous function config
 	router.GET("/info", func(c *gin.Context) {
@@ -91,7 +274,8 @@ func main() {
 	})
 
 	// handlers versioning - version 1
-	apiV1 := router.Group("/v1", handlers.AuthMiddleware())
+	// mutating requests with an Idempotency-Key are replayed instead of processed again
+	apiV1 := router.Group("/v1", handlers.AuthMiddleware(), handlers.IdempotencyMiddleware())
 	{
 		// handlers for get all course
 		apiV1.GET("/course", handlers.GetCourses)
@@ -99,51 +283,113 @@ func main() {
 		apiV1.GET("/labs", handlers.GetLabs)
 		// handlers for push score
 		apiV1.POST("/score", handlers.PushScore)
+		// handlers for push many scores at once, admins and api keys only
+		apiV1.POST("/score/bulk", handlers.PushScoresBulk)
 		// handlers for get score
 		apiV1.GET("/score", handlers.GetScore)
+		// handlers for get own attempts
+		apiV1.GET("/attempts", handlers.GetAttempts)
 		// handlers for check token with middleware
 		apiV1.POST("/auth/check", handlers.CheckToken)
+		// handlers for change own password
+		apiV1.POST("/me/password", handlers.ChangePassword)
+		// handlers for start TOTP enrollment
+		apiV1.POST("/me/mfa/totp", handlers.EnrollTOTP)
+		// handlers for confirm TOTP enrollment with a first code
+		apiV1.POST("/me/mfa/totp/verify", handlers.VerifyTOTP)
+		// handlers for disable TOTP
+		apiV1.DELETE("/me/mfa/totp", handlers.DisableTOTP)
+		// handlers for get own progress on every lab of a course
+		apiV1.GET("/me/progress", handlers.GetProgress)
+		// handlers for be anonymized on leaderboards
+		apiV1.PUT("/me/leaderboard", handlers.SetLeaderboardOptOut)
 
-		// admin handlers
-		admin := apiV1.Group("/admin", handlers.AdminOnly())
+		// admin handlers, each route check a permission on the class or course of the request
+		admin := apiV1.Group("/admin")
 		{
 			// handlers for check admin
-			admin.POST("/check", handlers.CheckToken)
+			admin.POST("/check", handlers.AdminOnly(), handlers.CheckToken)
 
 			// handlers for get all user
-			admin.GET("/user", handlers.GetUsers)
+			admin.GET("/user", handlers.RequirePermission(db.PermUsersRead, handlers.QueryScope), handlers.GetUsers)
 			// handlers for create user
-			admin.POST("/user", handlers.CreateUser)
+			admin.POST("/user", handlers.RequirePermission(db.PermUsersWrite, handlers.NoScope), handlers.CreateUser)
 			// handlers for delete user
-			admin.DELETE("/user", handlers.DeleteUser)
+			admin.DELETE("/user", handlers.RequirePermission(db.PermUsersWrite, handlers.UserScope("id")), handlers.DeleteUser)
 			// handlers for update user
-			admin.PUT("/user", handlers.UpdateUser)
+			admin.PUT("/user", handlers.RequirePermission(db.PermUsersWrite, handlers.UserScope("user_id")), handlers.UpdateUser)
+			// handlers for revoke all sessions of user
+			admin.POST("/user/revoke", handlers.RequirePermission(db.PermUsersWrite, handlers.UserScope("user_id")), handlers.RevokeUserSessions)
+			// handlers for reset user password with a temporary one
+			admin.POST("/user/password", handlers.RequirePermission(db.PermUsersWrite, handlers.UserScope("user_id")), handlers.ResetPassword)
+			// handlers for unlock user locked by failed logins
+			admin.POST("/user/unlock", handlers.RequirePermission(db.PermUsersWrite, handlers.UserScope("user_id")), handlers.UnlockUser)
 
 			// handlers for get all class
-			admin.GET("/class", handlers.GetClasses)
+			admin.GET("/class", handlers.RequirePermission(db.PermClassesRead, handlers.NoScope), handlers.GetClasses)
 			// handlers for create class
-			admin.POST("/class", handlers.CreateClass)
+			admin.POST("/class", handlers.RequirePermission(db.PermClassesWrite, handlers.NoScope), handlers.CreateClass)
 			// handlers for delete class
-			admin.DELETE("/class", handlers.DeleteClass)
+			admin.DELETE("/class", handlers.RequirePermission(db.PermClassesWrite, handlers.NoScope), handlers.DeleteClass)
 			// handlers for update class
-			admin.PUT("/class", handlers.UpdateClass)
+			admin.PUT("/class", handlers.RequirePermission(db.PermClassesWrite, handlers.NoScope), handlers.UpdateClass)
 
 			// handlers for create course
-			admin.POST("/course", handlers.CreateCourse)
+			admin.POST("/course", handlers.RequirePermission(db.PermCoursesWrite, handlers.NoScope), handlers.CreateCourse)
 			// handlers for update course
-			admin.PUT("/course", handlers.UpdateCourse)
+			admin.PUT("/course", handlers.RequirePermission(db.PermCoursesWrite, handlers.NoScope), handlers.UpdateCourse)
 			// handlers for delete course
-			admin.DELETE("/course", handlers.DeleteCourse)
+			admin.DELETE("/course", handlers.RequirePermission(db.PermCoursesWrite, handlers.NoScope), handlers.DeleteCourse)
+			// handlers for grading scheme of course
+			admin.GET("/course/grading", handlers.RequirePermission(db.PermScoresExport, handlers.QueryScope), handlers.GetGradeCategories)
+			admin.PUT("/course/grading", handlers.RequirePermission(db.PermLabsWrite, handlers.QueryScope), handlers.SetGradeCategories)
+			// handlers for signing secret of course
+			admin.POST("/course/secret", handlers.RequirePermission(db.PermCoursesWrite, handlers.NoScope), handlers.RotateCourseSecret)
 
 			// handlers for create labs
-			admin.POST("/labs", handlers.CreateLabs)
+			admin.POST("/labs", handlers.RequirePermission(db.PermLabsWrite, handlers.NoScope), handlers.CreateLabs)
 			// handlers for update labs
-			admin.PUT("/labs", handlers.UpdateLabs)
+			admin.PUT("/labs", handlers.RequirePermission(db.PermLabsWrite, handlers.LabScope("id")), handlers.UpdateLabs)
 			// handlers for delete labs
-			admin.DELETE("/labs", handlers.DeleteLabs)
+			admin.DELETE("/labs", handlers.RequirePermission(db.PermLabsWrite, handlers.LabScope("id")), handlers.DeleteLabs)
+			// handlers for signing secret of lab
+			admin.POST("/labs/secret", handlers.RequirePermission(db.PermLabsWrite, handlers.LabScope("id")), handlers.RotateLabSecret)
+			admin.DELETE("/labs/secret", handlers.RequirePermission(db.PermLabsWrite, handlers.LabScope("id")), handlers.ClearLabSecret)
+			// handlers for lab schedules of a class
+			admin.PUT("/labs/schedule", handlers.RequirePermission(db.PermLabsWrite, handlers.NoScope), handlers.SetLabSchedule)
+			admin.GET("/labs/schedule", handlers.RequirePermission(db.PermLabsWrite, handlers.LabScope("lab_id")), handlers.GetLabSchedules)
+			admin.DELETE("/labs/schedule", handlers.RequirePermission(db.PermLabsWrite, handlers.NoScope), handlers.DeleteLabSchedule)
+
+			// handlers for get attempt timeline of a student
+			admin.GET("/attempts", handlers.RequirePermission(db.PermUsersRead, handlers.UserScope("user_id")), handlers.GetUserAttempts)
+			// handlers for download the artifact uploaded with an attempt
+			admin.GET("/attempts/artifact", handlers.RequirePermission(db.PermUsersRead, handlers.AttemptScope("attempt_id")), handlers.GetAttemptArtifact)
+			// handlers for manual score override and its audit trail
+			admin.POST("/score/override", handlers.RequirePermission(db.PermScoresOverride, handlers.NoScope), handlers.OverrideScore)
+			admin.GET("/score/override", handlers.RequirePermission(db.PermScoresOverride, handlers.UserScope("user_id")), handlers.GetScoreOverrides)
 
 			// handlers for export score
-			admin.GET("/export", handlers.ExportScore)
+			admin.GET("/export", handlers.RequirePermission(db.PermScoresExport, handlers.QueryScope), handlers.ExportScore)
+			// handlers for ranked totals of a class on a course
+			admin.GET("/leaderboard", handlers.RequirePermission(db.PermScoresExport, handlers.QueryScope), handlers.GetLeaderboard)
+			// handlers for stream score changes of a class on a course as server-sent events
+			admin.GET("/stream", handlers.RequirePermission(db.PermScoresExport, handlers.QueryScope), handlers.StreamScores)
+
+			// handlers for get all roles with permissions
+			admin.GET("/role", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.GetRoles)
+			// handlers for get class assignments
+			admin.GET("/assignment", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.GetAssignments)
+			// handlers for assign user to class
+			admin.POST("/assignment", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.CreateAssignment)
+			// handlers for delete class assignment
+			admin.DELETE("/assignment", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.DeleteAssignment)
+
+			// handlers for get all api keys
+			admin.GET("/apikey", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.GetAPIKeys)
+			// handlers for create api key for automated graders
+			admin.POST("/apikey", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.CreateAPIKey)
+			// handlers for revoke api key
+			admin.DELETE("/apikey", handlers.RequirePermission(db.PermRolesManage, handlers.NoScope), handlers.RevokeAPIKey)
 
 		}
 	}
@@ -151,7 +397,7 @@ func main() {
 	// create http server on port 8080
 	server := &http.Server{
 		Addr:         ":9898",
-		Handler:      router,
+		Handler:      handlers.StreamHandler(router),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}